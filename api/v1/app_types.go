@@ -0,0 +1,464 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// AppSpec defines the desired state of App
+type AppSpec struct {
+	// Replicas is the number of pods of the Deployment.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Image of the container.
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy of the container. Defaults to the policy configured for
+	// the manager, or to the Kubernetes default.
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Ports exposed by the container. Defaults to a single http port 80.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Ports []AppPort `json:"ports,omitempty"`
+
+	// Service configures the Service of the App.
+	// +optional
+	Service *AppService `json:"service,omitempty"`
+	// Ingress configures the Ingress of the App. It requires the Service.
+	// +optional
+	Ingress *AppIngress `json:"ingress,omitempty"`
+
+	// Env sets environment variables of the container.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// EnvFrom populates environment variables of the container from ConfigMaps
+	// or Secrets. Pods are restarted when the referenced data changes; a Secret
+	// is compared by its ingress.zq.com/checksum annotation, or by its
+	// resourceVersion when it has none.
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// Config is inline configuration materialised into a ConfigMap named
+	// <name>-config, whose keys are exposed to the container as environment variables.
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+
+	// Resources of the container. Requests and limits that are omitted are
+	// filled from the resource profile by the defaulting webhook.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// ResourceProfile is the resource tier, e.g. small, medium or large, used to
+	// default Resources. Defaults to the profile of the namespace, or the
+	// cluster-wide default profile.
+	// +optional
+	ResourceProfile string `json:"resourceProfile,omitempty"`
+
+	// Probes configures the health checks of the container.
+	// +optional
+	Probes *AppProbes `json:"probes,omitempty"`
+
+	// Autoscaling creates a HorizontalPodAutoscaler for the Deployment. When
+	// set, Replicas is no longer written to the Deployment.
+	// +optional
+	Autoscaling *AppAutoscaling `json:"autoscaling,omitempty"`
+
+	// MinAvailable is the number or percentage of pods that must stay
+	// available during voluntary disruptions such as node drains. Setting it
+	// or MaxUnavailable creates a PodDisruptionBudget for the App.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// MaxUnavailable is the number or percentage of pods that may be
+	// unavailable during voluntary disruptions. Mutually exclusive with MinAvailable.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+
+	// DeletionPolicy controls what happens to the child resources when the App
+	// is deleted. Defaults to Delete.
+	// +kubebuilder:validation:Enum=Delete;Orphan;RetainService
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// AdoptionPolicy controls whether existing resources named like the App's
+	// children are taken over. Defaults to IfUnowned.
+	// +kubebuilder:validation:Enum=Never;IfUnowned;Force
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// DriftPolicy controls whether changes made to the child resources by other
+	// writers are reverted. Drift is reported in the status either way.
+	// Defaults to Correct.
+	// +kubebuilder:validation:Enum=Correct;ReportOnly
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// Suspend stops the controller from changing the child resources of the
+	// App, while its status is still updated. Clearing it resyncs all child
+	// resources. Deleting a suspended App still cleans up its children.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// Rollout configures how changes of Image are rolled out.
+	// +optional
+	Rollout *AppRollout `json:"rollout,omitempty"`
+
+	// RevisionHistoryLimit is the number of revisions of the spec kept as
+	// ControllerRevisions. Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// AppPort describes a named port of the App's container.
+type AppPort struct {
+	// Name of the port, referenced by the Service and the Ingress backend.
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// ContainerPort is the port the container listens on.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	ContainerPort int32 `json:"containerPort"`
+	// Protocol of the port. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+}
+
+// AppService configures the Service of the App.
+type AppService struct {
+	// Enabled creates the Service.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// Type of the Service. Defaults to ClusterIP.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// Ports customises how the Service exposes the container ports. Every
+	// container port is exposed on its own number unless listed here.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Ports []AppServicePort `json:"ports,omitempty"`
+}
+
+// AppServicePort customises how the Service exposes a container port.
+type AppServicePort struct {
+	// Name of the container port.
+	Name string `json:"name"`
+	// Port exposed by the Service. Defaults to the container port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// AppProtocol is the application protocol of the port, e.g. http or grpc.
+	// +optional
+	AppProtocol *string `json:"appProtocol,omitempty"`
+}
+
+// AppIngress configures how the App is exposed through its Ingress.
+type AppIngress struct {
+	// Enabled creates the Ingress.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// IngressClassName of the Ingress. Defaults to nginx.
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+	// Annotations added to the Ingress.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Hosts the App is exposed on. Defaults to <name>.zq.com.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+	// Paths routed to the App on every host. Defaults to / with pathType Prefix.
+	// +optional
+	Paths []AppIngressPath `json:"paths,omitempty"`
+	// TLS configuration of the Ingress, referencing secrets in the App's namespace.
+	// +optional
+	TLS []netv1.IngressTLS `json:"tls,omitempty"`
+}
+
+// AppIngressPath is a path routed to one of the App's ports.
+type AppIngressPath struct {
+	// Path matched against the path of incoming requests.
+	Path string `json:"path"`
+	// PathType of the path. Defaults to Prefix.
+	// +kubebuilder:validation:Enum=Exact;Prefix;ImplementationSpecific
+	// +optional
+	PathType *netv1.PathType `json:"pathType,omitempty"`
+	// Port is the name of the App port the path routes to. Defaults to the
+	// first TCP port.
+	// +optional
+	Port string `json:"port,omitempty"`
+}
+
+// AppProbes configures the health checks of the App's container.
+type AppProbes struct {
+	// HealthPath is a shorthand for HTTP readiness and liveness probes on this
+	// path of the ingress port. Probes set explicitly take precedence.
+	// +optional
+	HealthPath string `json:"healthPath,omitempty"`
+	// Liveness probe of the container.
+	// +optional
+	Liveness *corev1.Probe `json:"liveness,omitempty"`
+	// Readiness probe of the container.
+	// +optional
+	Readiness *corev1.Probe `json:"readiness,omitempty"`
+	// Startup probe of the container.
+	// +optional
+	Startup *corev1.Probe `json:"startup,omitempty"`
+}
+
+// AppAutoscaling configures the HorizontalPodAutoscaler of the App.
+type AppAutoscaling struct {
+	// MinReplicas is the lower limit of replicas. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the upper limit of replicas.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilizationPercentage is the target average CPU utilization of
+	// the pods, relative to their requests. Defaults to 80 when no other
+	// target or metric is set.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// TargetMemoryUtilizationPercentage is the target average memory
+	// utilization of the pods, relative to their requests.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+	// Metrics are additional metrics to scale on, e.g. pods or external metrics.
+	// +optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+}
+
+// DeletionPolicy describes what happens to the child resources of a deleted App.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the child resources together with the App.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps the child resources, released from the App.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetainService deletes the child resources except the
+	// Service, which is kept so that its cluster IP and node ports stay reserved.
+	DeletionPolicyRetainService DeletionPolicy = "RetainService"
+)
+
+// AdoptionPolicy describes how existing resources named like the App's
+// children are handled.
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever refuses to take over existing resources.
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+	// AdoptionPolicyIfUnowned takes over existing resources that have no controller.
+	AdoptionPolicyIfUnowned AdoptionPolicy = "IfUnowned"
+	// AdoptionPolicyForce takes over existing resources, replacing their controller.
+	AdoptionPolicyForce AdoptionPolicy = "Force"
+)
+
+// DriftPolicy describes how changes made to the child resources by other
+// writers are handled.
+type DriftPolicy string
+
+const (
+	// DriftPolicyCorrect reverts drifted fields to the rendered state.
+	DriftPolicyCorrect DriftPolicy = "Correct"
+	// DriftPolicyReportOnly leaves drifted fields in place until the App changes.
+	DriftPolicyReportOnly DriftPolicy = "ReportOnly"
+)
+
+// RolloutStrategy is the strategy used to roll out a new image.
+type RolloutStrategy string
+
+const (
+	// RolloutStrategyRollingUpdate updates the Deployment in place.
+	RolloutStrategyRollingUpdate RolloutStrategy = "RollingUpdate"
+	// RolloutStrategyCanary runs the new image in a canary Deployment next to
+	// the stable one and shifts traffic to it step by step. It splits
+	// spec.replicas and cannot be combined with autoscaling.
+	RolloutStrategyCanary RolloutStrategy = "Canary"
+	// RolloutStrategyBlueGreen runs the new image in a second Deployment of the
+	// other colour and switches the Service to it once it is available.
+	RolloutStrategyBlueGreen RolloutStrategy = "BlueGreen"
+)
+
+// AppRollout configures how changes of the App's image are rolled out.
+type AppRollout struct {
+	// Strategy of the rollout. Defaults to RollingUpdate.
+	// +kubebuilder:validation:Enum=RollingUpdate;Canary;BlueGreen
+	// +optional
+	Strategy RolloutStrategy `json:"strategy,omitempty"`
+	// Canary configures the Canary strategy.
+	// +optional
+	Canary *AppCanary `json:"canary,omitempty"`
+	// BlueGreen configures the BlueGreen strategy.
+	// +optional
+	BlueGreen *AppBlueGreen `json:"blueGreen,omitempty"`
+	// AutoRollback renders the child resources from the last known-good
+	// revision of the spec when a RollingUpdate rollout exceeds its progress
+	// deadline. The App stays rolled back until the spec is changed again.
+	// +optional
+	AutoRollback bool `json:"autoRollback,omitempty"`
+}
+
+// AppBlueGreen configures a blue/green rollout. The pods run in the
+// Deployments <name>-blue and <name>-green. The App's Service selects the
+// active colour, while the Service <name>-preview selects the other one.
+type AppBlueGreen struct {
+	// ManualPromotion holds the switch to the new colour, once it is available,
+	// until the App is annotated with ingress.zq.com/promote=true.
+	// +optional
+	ManualPromotion bool `json:"manualPromotion,omitempty"`
+}
+
+// AppCanary configures a canary rollout. The canary Deployment, named
+// <name>-canary, is selected by the App's Service, so it receives a share of
+// the traffic proportional to its share of the replicas.
+type AppCanary struct {
+	// Steps the canary goes through. The new image is promoted to the stable
+	// Deployment once the last step is complete.
+	// +kubebuilder:validation:MinItems=1
+	Steps []AppCanaryStep `json:"steps"`
+}
+
+// AppCanaryStep is a step of a canary rollout.
+type AppCanaryStep struct {
+	// Weight is the percentage of the App's replicas run by the canary.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+	// Pause is how long the step is held once the canary is ready, before
+	// moving on to the next step.
+	// +optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// AppRolloutStatus reports the progress of the rollout of the App's image.
+type AppRolloutStatus struct {
+	// Phase of the rollout: Progressing, Paused, Promoted or Aborted.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// StableImage is the image run by the stable Deployment.
+	// +optional
+	StableImage string `json:"stableImage,omitempty"`
+	// CanaryImage is the image being rolled out.
+	// +optional
+	CanaryImage string `json:"canaryImage,omitempty"`
+	// Step is the index of the current canary step.
+	// +optional
+	Step *int32 `json:"step,omitempty"`
+	// StepStartTime is when the canary of the current step became ready.
+	// +optional
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+	// ActiveColor is the colour of the Deployment selected by the Service
+	// during BlueGreen rollouts, blue or green.
+	// +optional
+	ActiveColor string `json:"activeColor,omitempty"`
+	// PreviewImage is the image being rolled out to the preview colour.
+	// +optional
+	PreviewImage string `json:"previewImage,omitempty"`
+	// Message is a human readable description of the rollout state.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// AppStatus defines the observed state of App
+type AppStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Replicas is the desired number of replicas of the Deployment.
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of ready pods of the Deployment.
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// URL is the address the App is exposed on through its Ingress.
+	URL string `json:"url,omitempty"`
+	// Selector is the label selector of the App's pods in string form, used by
+	// the scale subresource.
+	Selector string `json:"selector,omitempty"`
+	// DriftedFields lists the fields of the child resources that differ from
+	// the rendered state, as <kind>/<name>:<path>.
+	// +optional
+	DriftedFields []string `json:"driftedFields,omitempty"`
+
+	// Rollout reports the progress of the rollout of the App's image.
+	// +optional
+	Rollout *AppRolloutStatus `json:"rollout,omitempty"`
+	// LastGoodImage is the last image that was fully rolled out and available.
+	// +optional
+	LastGoodImage string `json:"lastGoodImage,omitempty"`
+	// LastGoodRevision is the last revision of the spec that was fully rolled
+	// out and available, the target of automatic rollbacks.
+	// +optional
+	LastGoodRevision int64 `json:"lastGoodRevision,omitempty"`
+	// RolledBackImage is the image of RolledBackRevision.
+	// +optional
+	RolledBackImage string `json:"rolledBackImage,omitempty"`
+	// RolledBackRevision is the revision of the spec whose failed rollout was
+	// reverted to LastGoodRevision.
+	// +optional
+	RolledBackRevision int64 `json:"rolledBackRevision,omitempty"`
+
+	// Conditions represent the latest available observations of the App's state.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Ready Replicas",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Service",type=boolean,JSONPath=`.spec.service.enabled`,priority=1
+//+kubebuilder:printcolumn:name="Ingress",type=boolean,JSONPath=`.spec.ingress.enabled`,priority=1
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+//+kubebuilder:storageversion
+
+// App is the Schema for the apps API
+type App struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AppSpec   `json:"spec,omitempty"`
+	Status AppStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AppList contains a list of App
+type AppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []App `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&App{}, &AppList{})
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/hdssbks/kubebuilder-demo/api/v1"
+)
+
+// v1SpecAnnotation 保存v1的spec中无法用v1beta1表达的部分，例如空的service、ingress对象以及service端口的顺序，
+// 转换回v1时据此恢复，保证v1 -> v1beta1 -> v1的转换无损
+const v1SpecAnnotation = "ingress.zq.com/v1-spec"
+
+var _ conversion.Convertible = &App{}
+
+// ConvertTo converts this App to the Hub version (v1).
+func (src *App) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.App)
+	in := src.DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = convertSpecToV1(in.Spec)
+	dst.Status = convertStatusToV1(in.Status)
+
+	raw, ok := dst.Annotations[v1SpecAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(dst.Annotations, v1SpecAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	var spec v1.AppSpec
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return err
+	}
+	// v1beta1的spec在转换后被修改过时，以修改后的内容为准
+	if equality.Semantic.DeepEqual(convertSpecFromV1(*spec.DeepCopy()), in.Spec) {
+		dst.Spec = spec
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version.
+func (dst *App) ConvertFrom(srcRaw conversion.Hub) error {
+	in := srcRaw.(*v1.App).DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = convertSpecFromV1(*in.Spec.DeepCopy())
+	dst.Status = convertStatusFromV1(in.Status)
+
+	// 只有转换有损时才保存v1的spec
+	if equality.Semantic.DeepEqual(convertSpecToV1(*dst.Spec.DeepCopy()), in.Spec) {
+		return nil
+	}
+	raw, err := json.Marshal(in.Spec)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[v1SpecAnnotation] = string(raw)
+	return nil
+}
+
+func convertSpecToV1(in AppSpec) v1.AppSpec {
+	out := v1.AppSpec{
+		Replicas:             in.Replicas,
+		Image:                in.Image,
+		ImagePullPolicy:      in.ImagePullPolicy,
+		Env:                  in.Env,
+		EnvFrom:              in.EnvFrom,
+		Config:               in.Config,
+		Resources:            in.Resources,
+		ResourceProfile:      in.ResourceProfile,
+		Probes:               (*v1.AppProbes)(in.Probes),
+		Autoscaling:          (*v1.AppAutoscaling)(in.Autoscaling),
+		MinAvailable:         in.MinAvailable,
+		MaxUnavailable:       in.MaxUnavailable,
+		DeletionPolicy:       v1.DeletionPolicy(in.DeletionPolicy),
+		AdoptionPolicy:       v1.AdoptionPolicy(in.AdoptionPolicy),
+		DriftPolicy:          v1.DriftPolicy(in.DriftPolicy),
+		Suspend:              in.Suspend,
+		RevisionHistoryLimit: in.RevisionHistoryLimit,
+	}
+	if in.Rollout != nil {
+		out.Rollout = &v1.AppRollout{Strategy: v1.RolloutStrategy(in.Rollout.Strategy), AutoRollback: in.Rollout.AutoRollback}
+		if in.Rollout.Canary != nil {
+			out.Rollout.Canary = &v1.AppCanary{}
+			for _, step := range in.Rollout.Canary.Steps {
+				out.Rollout.Canary.Steps = append(out.Rollout.Canary.Steps, v1.AppCanaryStep(step))
+			}
+		}
+		out.Rollout.BlueGreen = (*v1.AppBlueGreen)(in.Rollout.BlueGreen)
+	}
+
+	// v1beta1的端口同时描述了容器端口和service端口，v1中拆分为容器端口和service端口的定制
+	var servicePorts []v1.AppServicePort
+	for _, p := range in.Ports {
+		out.Ports = append(out.Ports, v1.AppPort{Name: p.Name, ContainerPort: p.ContainerPort, Protocol: p.Protocol})
+		if p.ServicePort != 0 || p.AppProtocol != nil {
+			servicePorts = append(servicePorts, v1.AppServicePort{Name: p.Name, Port: p.ServicePort, AppProtocol: p.AppProtocol})
+		}
+	}
+	if in.EnableSvc != nil || in.ServiceType != "" || servicePorts != nil {
+		out.Service = &v1.AppService{Enabled: in.EnableSvc, Type: in.ServiceType, Ports: servicePorts}
+	}
+
+	if in.EnableIngress != nil || in.Ingress != nil {
+		out.Ingress = &v1.AppIngress{Enabled: in.EnableIngress}
+		if ing := in.Ingress; ing != nil {
+			out.Ingress.IngressClassName = ing.IngressClassName
+			out.Ingress.Annotations = ing.Annotations
+			out.Ingress.Hosts = ing.Hosts
+			out.Ingress.TLS = ing.TLS
+			for _, p := range ing.Paths {
+				out.Ingress.Paths = append(out.Ingress.Paths, v1.AppIngressPath(p))
+			}
+		}
+	}
+	return out
+}
+
+func convertSpecFromV1(in v1.AppSpec) AppSpec {
+	out := AppSpec{
+		Replicas:             in.Replicas,
+		Image:                in.Image,
+		ImagePullPolicy:      in.ImagePullPolicy,
+		Env:                  in.Env,
+		EnvFrom:              in.EnvFrom,
+		Config:               in.Config,
+		Resources:            in.Resources,
+		ResourceProfile:      in.ResourceProfile,
+		Probes:               (*AppProbes)(in.Probes),
+		Autoscaling:          (*AppAutoscaling)(in.Autoscaling),
+		MinAvailable:         in.MinAvailable,
+		MaxUnavailable:       in.MaxUnavailable,
+		DeletionPolicy:       DeletionPolicy(in.DeletionPolicy),
+		AdoptionPolicy:       AdoptionPolicy(in.AdoptionPolicy),
+		DriftPolicy:          DriftPolicy(in.DriftPolicy),
+		Suspend:              in.Suspend,
+		RevisionHistoryLimit: in.RevisionHistoryLimit,
+	}
+	if in.Rollout != nil {
+		out.Rollout = &AppRollout{Strategy: RolloutStrategy(in.Rollout.Strategy), AutoRollback: in.Rollout.AutoRollback}
+		if in.Rollout.Canary != nil {
+			out.Rollout.Canary = &AppCanary{}
+			for _, step := range in.Rollout.Canary.Steps {
+				out.Rollout.Canary.Steps = append(out.Rollout.Canary.Steps, AppCanaryStep(step))
+			}
+		}
+		out.Rollout.BlueGreen = (*AppBlueGreen)(in.Rollout.BlueGreen)
+	}
+
+	for _, p := range in.Ports {
+		port := AppPort{Name: p.Name, ContainerPort: p.ContainerPort, Protocol: p.Protocol}
+		if in.Service != nil {
+			for _, sp := range in.Service.Ports {
+				if sp.Name == p.Name {
+					port.ServicePort, port.AppProtocol = sp.Port, sp.AppProtocol
+					break
+				}
+			}
+		}
+		out.Ports = append(out.Ports, port)
+	}
+	if in.Service != nil {
+		out.EnableSvc = in.Service.Enabled
+		out.ServiceType = in.Service.Type
+	}
+
+	if in.Ingress != nil {
+		out.EnableIngress = in.Ingress.Enabled
+		ing := &AppIngress{
+			IngressClassName: in.Ingress.IngressClassName,
+			Annotations:      in.Ingress.Annotations,
+			Hosts:            in.Ingress.Hosts,
+			TLS:              in.Ingress.TLS,
+		}
+		for _, p := range in.Ingress.Paths {
+			ing.Paths = append(ing.Paths, AppIngressPath(p))
+		}
+		// 没有enabled也没有其他配置的ingress对象需要保留，否则会被转换为未设置ingress
+		if !equality.Semantic.DeepEqual(*ing, AppIngress{}) || in.Ingress.Enabled == nil {
+			out.Ingress = ing
+		}
+	}
+	return out
+}
+
+func convertStatusToV1(in AppStatus) v1.AppStatus {
+	out := v1.AppStatus{
+		ObservedGeneration: in.ObservedGeneration,
+		Replicas:           in.Replicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		URL:                in.URL,
+		Selector:           in.Selector,
+		DriftedFields:      in.DriftedFields,
+		LastGoodImage:      in.LastGoodImage,
+		LastGoodRevision:   in.LastGoodRevision,
+		RolledBackImage:    in.RolledBackImage,
+		RolledBackRevision: in.RolledBackRevision,
+		Conditions:         in.Conditions,
+	}
+	if in.Rollout != nil {
+		rollout := v1.AppRolloutStatus(*in.Rollout)
+		out.Rollout = &rollout
+	}
+	return out
+}
+
+func convertStatusFromV1(in v1.AppStatus) AppStatus {
+	out := AppStatus{
+		ObservedGeneration: in.ObservedGeneration,
+		Replicas:           in.Replicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		URL:                in.URL,
+		Selector:           in.Selector,
+		DriftedFields:      in.DriftedFields,
+		LastGoodImage:      in.LastGoodImage,
+		LastGoodRevision:   in.LastGoodRevision,
+		RolledBackImage:    in.RolledBackImage,
+		RolledBackRevision: in.RolledBackRevision,
+		Conditions:         in.Conditions,
+	}
+	if in.Rollout != nil {
+		rollout := AppRolloutStatus(*in.Rollout)
+		out.Rollout = &rollout
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"math/rand"
+
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/hdssbks/kubebuilder-demo/api/v1"
+)
+
+// conversionFuzzerFuncs 把随机生成的对象限制在能被v1beta1表达的范围内
+func conversionFuzzerFuncs(_ serializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(q *resource.Quantity, c fuzz.Continue) {
+			*q = *resource.NewQuantity(c.Int63n(1000), resource.DecimalSI)
+		},
+		func(tm *metav1.TypeMeta, _ fuzz.Continue) {
+			*tm = metav1.TypeMeta{}
+		},
+		func(spec *AppSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			// 端口按名字关联service端口，名字需要唯一
+			for i := range spec.Ports {
+				spec.Ports[i].Name = fmt.Sprintf("port-%d", i)
+			}
+			// enableIngress已设置时，空的ingress和未设置ingress等价
+			if spec.EnableIngress != nil && spec.Ingress != nil && equality.Semantic.DeepEqual(*spec.Ingress, AppIngress{}) {
+				spec.Ingress = nil
+			}
+		},
+	}
+}
+
+var _ = Describe("App Conversion", func() {
+
+	newFuzzer := func() *fuzz.Fuzzer {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		Expect(v1.AddToScheme(scheme)).To(Succeed())
+		funcs := fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, conversionFuzzerFuncs)
+		return fuzzer.FuzzerFor(funcs, rand.NewSource(GinkgoRandomSeed()), serializer.NewCodecFactory(scheme))
+	}
+
+	It("Should convert a v1beta1 App to v1 and back without loss", func() {
+		f := newFuzzer()
+		for i := 0; i < 1000; i++ {
+			src := &App{}
+			f.Fuzz(src)
+
+			hub := &v1.App{}
+			Expect(src.ConvertTo(hub)).To(Succeed())
+			dst := &App{}
+			Expect(dst.ConvertFrom(hub)).To(Succeed())
+			Expect(equality.Semantic.DeepEqual(src, dst)).To(BeTrue(), "round trip of %+v", src)
+		}
+	})
+
+	It("Should convert a v1 App to v1beta1 and back without loss", func() {
+		f := newFuzzer()
+		for i := 0; i < 1000; i++ {
+			src := &v1.App{}
+			f.Fuzz(src)
+
+			spoke := &App{}
+			Expect(spoke.ConvertFrom(src)).To(Succeed())
+			dst := &v1.App{}
+			Expect(spoke.ConvertTo(dst)).To(Succeed())
+			Expect(equality.Semantic.DeepEqual(src, dst)).To(BeTrue(), "round trip of %+v", src)
+		}
+	})
+
+	It("Should map the service and ingress settings between versions", func() {
+		src := &App{
+			ObjectMeta: metav1.ObjectMeta{Name: "convert", Namespace: "default"},
+			Spec: AppSpec{
+				EnableSvc:     ptr.To(true),
+				EnableIngress: ptr.To(false),
+				ServiceType:   "NodePort",
+				Ports: []AppPort{
+					{Name: "http", ContainerPort: 8080, ServicePort: 80},
+					{Name: "metrics", ContainerPort: 9090},
+				},
+			},
+		}
+		hub := &v1.App{}
+		Expect(src.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Ports).To(Equal([]v1.AppPort{
+			{Name: "http", ContainerPort: 8080},
+			{Name: "metrics", ContainerPort: 9090},
+		}))
+		Expect(hub.Spec.Service).To(Equal(&v1.AppService{
+			Enabled: ptr.To(true),
+			Type:    "NodePort",
+			Ports:   []v1.AppServicePort{{Name: "http", Port: 80}},
+		}))
+		Expect(hub.Spec.Ingress).To(Equal(&v1.AppIngress{Enabled: ptr.To(false)}))
+		Expect(hub.Annotations).To(BeEmpty())
+	})
+})
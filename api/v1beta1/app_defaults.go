@@ -0,0 +1,139 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/utils/ptr"
+)
+
+// AppDefaults are the values the defaulting webhook sets on Apps that omit
+// them, and the resource profiles the controller completes resources from.
+type AppDefaults struct {
+	// EnableSvc is the default of spec.enableSvc.
+	EnableSvc bool `json:"enableSvc"`
+	// EnableIngress is the default of spec.enableIngress. It is only applied
+	// to Apps whose Service is enabled.
+	EnableIngress bool `json:"enableIngress"`
+	// Replicas is the default of spec.replicas for Apps without autoscaling.
+	Replicas int32 `json:"replicas"`
+	// ImagePullPolicy is the default of spec.imagePullPolicy. When empty the
+	// policy is left to Kubernetes.
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Labels are added to the App unless it already sets them.
+	Labels map[string]string `json:"labels,omitempty"`
+	// ResourceProfiles are the resource tiers Apps select with
+	// spec.resourceProfile or their namespace's annotation. When set they
+	// replace the built-in small, medium and large tiers.
+	ResourceProfiles map[string]corev1.ResourceRequirements `json:"resourceProfiles,omitempty"`
+}
+
+// Defaults are the defaults applied by App.Default. The manager replaces them
+// with the configuration loaded by LoadAppDefaults at startup.
+var Defaults = AppDefaults{
+	Replicas:         1,
+	ResourceProfiles: builtinResourceProfiles,
+}
+
+// LoadAppDefaults reads AppDefaults from a YAML or JSON file. Fields the file
+// omits keep the values of Defaults.
+func LoadAppDefaults(path string) (AppDefaults, error) {
+	d := Defaults
+	d.Labels = maps.Clone(Defaults.Labels)
+	// 配置文件中的资源档位整体替换内置档位，而不是与之合并
+	d.ResourceProfiles = nil
+	if err := decodeFile(path, &d); err != nil {
+		return AppDefaults{}, err
+	}
+	if d.ResourceProfiles == nil {
+		d.ResourceProfiles = Defaults.ResourceProfiles
+	}
+	if errs := d.validate(); len(errs) > 0 {
+		return AppDefaults{}, fmt.Errorf("invalid defaults in %s: %w", path, errs.ToAggregate())
+	}
+	return d, nil
+}
+
+// decodeFile 将YAML或JSON格式的配置文件解码到into中，不允许出现未知字段，以便尽早发现拼写错误
+func decodeFile(path string, into interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	b, err = yaml.ToJSON(b)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(into); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+func (d AppDefaults) validate() field.ErrorList {
+	var allErrs field.ErrorList
+	if d.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("replicas"), d.Replicas, "must be greater than or equal to 0"))
+	}
+	switch d.ImagePullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("imagePullPolicy"), d.ImagePullPolicy,
+			[]string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)}))
+	}
+	allErrs = append(allErrs, metav1validation.ValidateLabels(d.Labels, field.NewPath("labels"))...)
+	allErrs = append(allErrs, validResourceProfiles(d.ResourceProfiles, field.NewPath("resourceProfiles"))...)
+	return allErrs
+}
+
+// defaultSpec 只填充App中未设置的字段，已设置的值保持不变，因此重复执行的结果相同
+func (r *App) defaultSpec(d AppDefaults) {
+	if r.Spec.EnableSvc == nil {
+		r.Spec.EnableSvc = ptr.To(d.EnableSvc)
+	}
+	// ingress依赖于service，service未开启时默认不开启ingress
+	if r.Spec.EnableIngress == nil {
+		r.Spec.EnableIngress = ptr.To(d.EnableIngress && *r.Spec.EnableSvc)
+	}
+	// 开启自动扩缩容时replicas由HPA管理
+	if r.Spec.Replicas == nil && r.Spec.Autoscaling == nil {
+		r.Spec.Replicas = ptr.To(d.Replicas)
+	}
+	if r.Spec.ImagePullPolicy == "" {
+		r.Spec.ImagePullPolicy = d.ImagePullPolicy
+	}
+	for k, v := range d.Labels {
+		if _, ok := r.Labels[k]; ok {
+			continue
+		}
+		if r.Labels == nil {
+			r.Labels = map[string]string{}
+		}
+		r.Labels[k] = v
+	}
+}
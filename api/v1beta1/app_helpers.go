@@ -0,0 +1,275 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// 以下方法计算App实际生效的配置（填充默认值后），供模板渲染、controller以及webhook共同使用
+
+const (
+	// DefaultIngressClassName is the ingress class used when none is configured.
+	DefaultIngressClassName = "nginx"
+	// DefaultIngressDomain is the domain the default ingress host is created under.
+	DefaultIngressDomain = "zq.com"
+)
+
+// DefaultTargetCPUUtilizationPercentage is the CPU target used when autoscaling
+// does not set any target or metric.
+const DefaultTargetCPUUtilizationPercentage int32 = 80
+
+// DefaultPort is the port used when an App does not declare any.
+var DefaultPort = AppPort{Name: "http", ContainerPort: 80, ServicePort: 80, Protocol: corev1.ProtocolTCP}
+
+// EffectivePorts returns the ports of the App with defaults applied.
+func (r *App) EffectivePorts() []AppPort {
+	if len(r.Spec.Ports) == 0 {
+		return []AppPort{DefaultPort}
+	}
+	ports := make([]AppPort, 0, len(r.Spec.Ports))
+	for _, p := range r.Spec.Ports {
+		p := *p.DeepCopy()
+		if p.ServicePort == 0 {
+			p.ServicePort = p.ContainerPort
+		}
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// IngressPort returns the port the Ingress routes to, the first TCP port of the App.
+func (r *App) IngressPort() AppPort {
+	ports := r.EffectivePorts()
+	for _, p := range ports {
+		if p.Protocol == corev1.ProtocolTCP {
+			return p
+		}
+	}
+	return ports[0]
+}
+
+// ServicePortFor returns the Service port of the App port with the given name,
+// or the port of IngressPort when name is empty or unknown.
+func (r *App) ServicePortFor(name string) int32 {
+	for _, p := range r.EffectivePorts() {
+		if p.Name == name {
+			return p.ServicePort
+		}
+	}
+	return r.IngressPort().ServicePort
+}
+
+// IngressClassName returns the ingress class of the App's Ingress.
+func (r *App) IngressClassName() string {
+	if r.Spec.Ingress != nil && r.Spec.Ingress.IngressClassName != nil {
+		return *r.Spec.Ingress.IngressClassName
+	}
+	return DefaultIngressClassName
+}
+
+// IngressHosts returns the hosts the App is exposed on.
+func (r *App) IngressHosts() []string {
+	if r.Spec.Ingress != nil && len(r.Spec.Ingress.Hosts) > 0 {
+		return r.Spec.Ingress.Hosts
+	}
+	return []string{r.Name + "." + DefaultIngressDomain}
+}
+
+// IngressPaths returns the paths routed to the App with defaults applied.
+func (r *App) IngressPaths() []AppIngressPath {
+	if r.Spec.Ingress == nil || len(r.Spec.Ingress.Paths) == 0 {
+		prefix := netv1.PathTypePrefix
+		return []AppIngressPath{{Path: "/", PathType: &prefix, Port: r.IngressPort().Name}}
+	}
+	paths := make([]AppIngressPath, 0, len(r.Spec.Ingress.Paths))
+	for _, p := range r.Spec.Ingress.Paths {
+		p := *p.DeepCopy()
+		if p.PathType == nil {
+			prefix := netv1.PathTypePrefix
+			p.PathType = &prefix
+		}
+		if p.Port == "" {
+			p.Port = r.IngressPort().Name
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// ConfigMapName returns the name of the ConfigMap holding the App's inline config.
+func (r *App) ConfigMapName() string {
+	return r.Name + "-config"
+}
+
+// CanaryName returns the name of the canary Deployment of the App.
+func (r *App) CanaryName() string {
+	return r.Name + "-canary"
+}
+
+// CanaryEnabled reports whether image changes are rolled out through a canary
+// Deployment. Autoscaled Apps are rolled out in place.
+func (r *App) CanaryEnabled() bool {
+	rollout := r.Spec.Rollout
+	return rollout != nil && rollout.Strategy == RolloutStrategyCanary && rollout.Canary != nil && len(rollout.Canary.Steps) > 0 &&
+		r.Spec.Autoscaling == nil
+}
+
+// BlueGreenEnabled reports whether image changes are rolled out by switching
+// between a blue and a green Deployment.
+func (r *App) BlueGreenEnabled() bool {
+	return r.Spec.Rollout != nil && r.Spec.Rollout.Strategy == RolloutStrategyBlueGreen
+}
+
+// AutoRollbackEnabled reports whether failed RollingUpdate rollouts are
+// reverted to the last known-good revision of the spec.
+func (r *App) AutoRollbackEnabled() bool {
+	return r.Spec.Rollout != nil && r.Spec.Rollout.AutoRollback && !r.CanaryEnabled() && !r.BlueGreenEnabled()
+}
+
+// ColorDeploymentName returns the name of the Deployment of the given colour.
+func (r *App) ColorDeploymentName(color string) string {
+	return r.Name + "-" + color
+}
+
+// ActiveDeploymentName returns the name of the Deployment serving the App's
+// traffic, which is the Deployment of the active colour for BlueGreen rollouts.
+func (r *App) ActiveDeploymentName() string {
+	if r.BlueGreenEnabled() && r.Status.Rollout != nil && r.Status.Rollout.ActiveColor != "" {
+		return r.ColorDeploymentName(r.Status.Rollout.ActiveColor)
+	}
+	return r.Name
+}
+
+// PreviewServiceName returns the name of the Service selecting the preview
+// colour of a BlueGreen rollout.
+func (r *App) PreviewServiceName() string {
+	return r.Name + "-preview"
+}
+
+// EffectiveEnvFrom returns the envFrom sources of the container, including the
+// ConfigMap holding the App's inline config.
+func (r *App) EffectiveEnvFrom() []corev1.EnvFromSource {
+	envFrom := r.Spec.EnvFrom
+	if len(r.Spec.Config) > 0 {
+		envFrom = append(envFrom[:len(envFrom):len(envFrom)], corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: r.ConfigMapName()},
+			},
+		})
+	}
+	return envFrom
+}
+
+// healthPathProbe 根据healthPath生成访问ingress端口的HTTP探针
+func (r *App) healthPathProbe(initialDelaySeconds, periodSeconds int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: r.Spec.Probes.HealthPath,
+				Port: intstr.FromString(r.IngressPort().Name),
+			},
+		},
+		InitialDelaySeconds: initialDelaySeconds,
+		PeriodSeconds:       periodSeconds,
+		FailureThreshold:    3,
+	}
+}
+
+// LivenessProbe returns the liveness probe of the container, if any.
+func (r *App) LivenessProbe() *corev1.Probe {
+	if r.Spec.Probes == nil {
+		return nil
+	}
+	if r.Spec.Probes.Liveness != nil || r.Spec.Probes.HealthPath == "" {
+		return r.Spec.Probes.Liveness
+	}
+	return r.healthPathProbe(15, 20)
+}
+
+// ReadinessProbe returns the readiness probe of the container, if any.
+func (r *App) ReadinessProbe() *corev1.Probe {
+	if r.Spec.Probes == nil {
+		return nil
+	}
+	if r.Spec.Probes.Readiness != nil || r.Spec.Probes.HealthPath == "" {
+		return r.Spec.Probes.Readiness
+	}
+	return r.healthPathProbe(5, 10)
+}
+
+// StartupProbe returns the startup probe of the container, if any.
+func (r *App) StartupProbe() *corev1.Probe {
+	if r.Spec.Probes == nil {
+		return nil
+	}
+	return r.Spec.Probes.Startup
+}
+
+// AutoscalingMetrics returns the metrics the HorizontalPodAutoscaler scales on.
+func (r *App) AutoscalingMetrics() []autoscalingv2.MetricSpec {
+	as := r.Spec.Autoscaling
+	if as == nil {
+		return nil
+	}
+	resourceMetric := func(name corev1.ResourceName, utilization int32) autoscalingv2.MetricSpec {
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: name,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: ptr.To(utilization),
+				},
+			},
+		}
+	}
+
+	var metrics []autoscalingv2.MetricSpec
+	cpu := as.TargetCPUUtilizationPercentage
+	if cpu == nil && as.TargetMemoryUtilizationPercentage == nil && len(as.Metrics) == 0 {
+		cpu = ptr.To(DefaultTargetCPUUtilizationPercentage)
+	}
+	if cpu != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceCPU, *cpu))
+	}
+	if mem := as.TargetMemoryUtilizationPercentage; mem != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceMemory, *mem))
+	}
+	return append(metrics, as.Metrics...)
+}
+
+// DisruptionBudgetEnabled reports whether a PodDisruptionBudget is created for the App.
+func (r *App) DisruptionBudgetEnabled() bool {
+	return r.Spec.MinAvailable != nil || r.Spec.MaxUnavailable != nil
+}
+
+// MinReplicas returns the lowest number of replicas the Deployment runs with:
+// the minimum of the autoscaler when autoscaling, Replicas otherwise.
+func (r *App) MinReplicas() int32 {
+	if as := r.Spec.Autoscaling; as != nil {
+		return ptr.Deref(as.MinReplicas, 1)
+	}
+	return ptr.Deref(r.Spec.Replicas, 1)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ImagePolicyAction is what the validating webhook does when an image violates a rule.
+type ImagePolicyAction string
+
+const (
+	// ImagePolicyDeny rejects the App.
+	ImagePolicyDeny ImagePolicyAction = "Deny"
+	// ImagePolicyWarn admits the App with a warning.
+	ImagePolicyWarn ImagePolicyAction = "Warn"
+)
+
+// ImagePolicy restricts the images Apps may run. Images that are not valid
+// references are always rejected.
+type ImagePolicy struct {
+	// AllowedRegistries are the registries, optionally followed by a
+	// repository prefix, images may be pulled from, e.g. registry.zq.com or
+	// docker.io/library. Empty allows every registry.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// RegistryAction applies to images from other registries. Defaults to Deny.
+	RegistryAction ImagePolicyAction `json:"registryAction,omitempty"`
+
+	// ForbiddenTags may not be used by images that are not pinned by digest.
+	// An image without tag uses latest.
+	ForbiddenTags []string `json:"forbiddenTags,omitempty"`
+	// TagAction applies to images using a forbidden tag. Defaults to Warn.
+	TagAction ImagePolicyAction `json:"tagAction,omitempty"`
+
+	// DigestNamespaces are the namespaces whose Apps must pin images by
+	// digest. "*" matches every namespace.
+	DigestNamespaces []string `json:"digestNamespaces,omitempty"`
+	// DigestAction applies to images without digest in DigestNamespaces.
+	// Defaults to Deny.
+	DigestAction ImagePolicyAction `json:"digestAction,omitempty"`
+}
+
+// Images is the image policy enforced by the validating webhook. The manager
+// replaces it with the policy loaded by LoadImagePolicy at startup.
+var Images = ImagePolicy{
+	ForbiddenTags: []string{"latest"},
+}
+
+// LoadImagePolicy reads an ImagePolicy from a YAML or JSON file.
+func LoadImagePolicy(path string) (ImagePolicy, error) {
+	var p ImagePolicy
+	if err := decodeFile(path, &p); err != nil {
+		return ImagePolicy{}, err
+	}
+	var allErrs field.ErrorList
+	for _, a := range []struct {
+		name   string
+		action ImagePolicyAction
+	}{
+		{"registryAction", p.RegistryAction},
+		{"tagAction", p.TagAction},
+		{"digestAction", p.DigestAction},
+	} {
+		if a.action != "" && a.action != ImagePolicyDeny && a.action != ImagePolicyWarn {
+			allErrs = append(allErrs, field.NotSupported(field.NewPath(a.name), a.action,
+				[]string{string(ImagePolicyDeny), string(ImagePolicyWarn)}))
+		}
+	}
+	if len(allErrs) > 0 {
+		return ImagePolicy{}, fmt.Errorf("invalid image policy in %s: %w", path, allErrs.ToAggregate())
+	}
+	return p, nil
+}
+
+func (a ImagePolicyAction) orDefault(def ImagePolicyAction) ImagePolicyAction {
+	if a == "" {
+		return def
+	}
+	return a
+}
+
+// validImage 按镜像策略校验App的镜像，违反Warn规则时只返回警告，违反Deny规则时返回错误
+func (r *App) validImage(policy ImagePolicy, fldPath *field.Path) (admission.Warnings, field.ErrorList) {
+	image := r.Spec.Image
+	if image == "" {
+		return nil, field.ErrorList{field.Required(fldPath, "")}
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(fldPath, image, err.Error())}
+	}
+
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	violate := func(action ImagePolicyAction, msg string) {
+		if action == ImagePolicyWarn {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", fldPath, msg))
+		} else {
+			allErrs = append(allErrs, field.Forbidden(fldPath, msg))
+		}
+	}
+
+	if len(policy.AllowedRegistries) > 0 && !slices.ContainsFunc(policy.AllowedRegistries, func(allowed string) bool {
+		allowed = strings.TrimSuffix(allowed, "/")
+		return named.Name() == allowed || strings.HasPrefix(named.Name(), allowed+"/")
+	}) {
+		violate(policy.RegistryAction.orDefault(ImagePolicyDeny), fmt.Sprintf("image %q is not from an allowed registry (%s)",
+			image, strings.Join(policy.AllowedRegistries, ", ")))
+	}
+
+	_, digested := named.(reference.Digested)
+	if !digested {
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok && slices.Contains(policy.ForbiddenTags, tagged.Tag()) {
+			violate(policy.TagAction.orDefault(ImagePolicyWarn), fmt.Sprintf("image %q uses the forbidden tag %q, pin a version or digest instead",
+				image, tagged.Tag()))
+		}
+		if slices.Contains(policy.DigestNamespaces, r.Namespace) || slices.Contains(policy.DigestNamespaces, "*") {
+			violate(policy.DigestAction.orDefault(ImagePolicyDeny), fmt.Sprintf("image %q must be pinned by digest in namespace %s",
+				image, r.Namespace))
+		}
+	}
+	return warnings, allErrs
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DeleteProtectionAnnotation set to "true" on an App, or on its
+	// Namespace, denies deleting the App.
+	DeleteProtectionAnnotation = "ingress.zq.com/delete-protection"
+	// AllowDeleteAnnotation set to "true" on an App allows deleting it even
+	// when it is protected.
+	AllowDeleteAnnotation = "ingress.zq.com/allow-delete"
+)
+
+// ProtectedNamespaces are the namespaces whose Apps can only be deleted with
+// the AllowDeleteAnnotation. The manager sets them from its flags.
+var ProtectedNamespaces []string
+
+// deleteProtection returns why the App is protected against deletion, or an
+// empty string when it is not.
+func (r *App) deleteProtection(ctx context.Context) (string, error) {
+	if r.Annotations[DeleteProtectionAnnotation] == "true" {
+		return fmt.Sprintf("the App has the annotation %s=true", DeleteProtectionAnnotation), nil
+	}
+	if slices.Contains(ProtectedNamespaces, r.Namespace) {
+		return fmt.Sprintf("namespace %s is protected", r.Namespace), nil
+	}
+	if appClient != nil {
+		ns := &corev1.Namespace{}
+		if err := appClient.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns); err != nil {
+			if !errors.IsNotFound(err) {
+				return "", err
+			}
+		} else if ns.Annotations[DeleteProtectionAnnotation] == "true" {
+			return fmt.Sprintf("namespace %s has the annotation %s=true", r.Namespace, DeleteProtectionAnnotation), nil
+		}
+	}
+	return "", nil
+}
+
+// validDelete 受保护的App只有在设置了允许删除的注解后才能被删除
+func (r *App) validDelete(ctx context.Context) error {
+	if r.Annotations[AllowDeleteAnnotation] == "true" {
+		return nil
+	}
+	reason, err := r.deleteProtection(ctx)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	if reason == "" {
+		return nil
+	}
+	return errors.NewForbidden(GroupVersion.WithResource("apps").GroupResource(), r.Name,
+		fmt.Errorf("%s, annotate it with %s=true to delete it", reason, AllowDeleteAnnotation))
+}
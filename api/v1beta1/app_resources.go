@@ -0,0 +1,174 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ResourceProfileAnnotation on a Namespace selects the resource profile of the
+// Apps in it that do not set one.
+const ResourceProfileAnnotation = "ingress.zq.com/resource-profile"
+
+// DefaultResourceProfile is the cluster-wide resource profile, used when neither
+// the App nor its namespace selects one.
+var DefaultResourceProfile = "small"
+
+// builtinResourceProfiles are the resource tiers used unless the defaults file
+// configures resourceProfiles.
+var builtinResourceProfiles = map[string]corev1.ResourceRequirements{
+	"small": {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	},
+	"medium": {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	},
+	"large": {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+	},
+}
+
+// resourceProfileNames returns the sorted names of the configured resource profiles.
+func resourceProfileNames() []string {
+	names := make([]string, 0, len(Defaults.ResourceProfiles))
+	for name := range Defaults.ResourceProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resourceProfile returns the name of the profile used to default the App's
+// resources: the App's own, the one selected by its namespace ns, or
+// DefaultResourceProfile. When the namespace selects a profile that does not
+// exist it returns DefaultResourceProfile together with an error.
+func (r *App) resourceProfile(ns *corev1.Namespace) (string, error) {
+	if r.Spec.ResourceProfile != "" {
+		return r.Spec.ResourceProfile, nil
+	}
+	if ns != nil {
+		if profile := ns.Annotations[ResourceProfileAnnotation]; profile != "" {
+			if _, ok := Defaults.ResourceProfiles[profile]; !ok {
+				return DefaultResourceProfile, fmt.Errorf("namespace %s selects the unknown resource profile %q with %s, supported profiles: %s",
+					ns.Name, profile, ResourceProfileAnnotation, strings.Join(resourceProfileNames(), ", "))
+			}
+			return profile, nil
+		}
+	}
+	return DefaultResourceProfile, nil
+}
+
+// namespace returns the Namespace of the App, or nil when it cannot be read.
+func (r *App) namespace(ctx context.Context) (*corev1.Namespace, error) {
+	if appClient == nil || r.Namespace == "" {
+		return nil, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := appClient.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return ns, nil
+}
+
+// defaultResources 用资源档位补全App未设置的requests和limits，
+// 已设置的值保持不变，并保证补全的limit不小于用户设置的request。
+// 默认值写入spec，修改档位或默认值文件只影响之后创建或更新的App，不会重建已有App的pod
+func (r *App) defaultResources(ctx context.Context) {
+	ns, err := r.namespace(ctx)
+	if err != nil {
+		applog.Error(err, "get namespace failed, using the default resource profile", "namespace", r.Namespace)
+	}
+	// namespace选择了不存在的档位时使用全局默认档位，由validApp给出警告
+	name, _ := r.resourceProfile(ns)
+	profile, ok := Defaults.ResourceProfiles[name]
+	if !ok {
+		return
+	}
+	if r.Spec.Resources == nil {
+		r.Spec.Resources = &corev1.ResourceRequirements{}
+	}
+	res := r.Spec.Resources
+	for name, quantity := range profile.Requests {
+		if _, ok := res.Requests[name]; ok {
+			continue
+		}
+		// 只设置了limit时，由kubernetes将request默认为limit
+		if _, ok := res.Limits[name]; ok {
+			continue
+		}
+		if res.Requests == nil {
+			res.Requests = corev1.ResourceList{}
+		}
+		res.Requests[name] = quantity
+	}
+	for name, quantity := range profile.Limits {
+		if _, ok := res.Limits[name]; ok {
+			continue
+		}
+		if request, ok := res.Requests[name]; ok && request.Cmp(quantity) > 0 {
+			quantity = request
+		}
+		if res.Limits == nil {
+			res.Limits = corev1.ResourceList{}
+		}
+		res.Limits[name] = quantity
+	}
+}
+
+// validResourceProfiles 校验配置的资源档位，request不能大于limit
+func validResourceProfiles(profiles map[string]corev1.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, profile := range profiles {
+		for resName, request := range profile.Requests {
+			if limit, ok := profile.Limits[resName]; ok && request.Cmp(limit) > 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(name).Child("requests").Key(string(resName)), request.String(),
+					"must be less than or equal to the limit"))
+			}
+		}
+	}
+	return allErrs
+}
@@ -17,10 +17,26 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -29,8 +45,12 @@ import (
 // log is for logging in this package.
 var applog = logf.Log.WithName("app-resource")
 
+// appClient 用于在webhook中查询集群中已存在的App和Ingress，由SetupWebhookWithManager设置
+var appClient client.Reader
+
 // SetupWebhookWithManager will setup the manager to manage the webhooks
 func (r *App) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	appClient = mgr.GetClient()
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
@@ -38,6 +58,9 @@ func (r *App) SetupWebhookWithManager(mgr ctrl.Manager) error {
 
 // TODO(user): EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 
+// 默认资源档位可以通过namespace的注解指定，需要读取namespace的权限
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
 //+kubebuilder:webhook:path=/mutate-ingress-zq-com-v1beta1-app,mutating=true,failurePolicy=fail,sideEffects=None,groups=ingress.zq.com,resources=apps,verbs=create;update,versions=v1beta1,name=mapp.kb.io,admissionReviewVersions=v1
 
 var _ webhook.Defaulter = &App{}
@@ -47,13 +70,12 @@ var _ webhook.Defaulter = &App{}
 func (r *App) Default() {
 	applog.Info("default", "name", r.Name)
 
-	// TODO(user): fill in your defaulting logic.
-	*r.Spec.EnableIngress = !*r.Spec.EnableIngress
+	r.defaultSpec(Defaults)
+	r.defaultResources(context.Background())
 }
 
-// TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
 // 在使用kubebuilder create webhook后，需要使用make manifests以创建webhook的manifests
-//+kubebuilder:webhook:path=/validate-ingress-zq-com-v1beta1-app,mutating=false,failurePolicy=fail,sideEffects=None,groups=ingress.zq.com,resources=apps,verbs=create;update,versions=v1beta1,name=vapp.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-ingress-zq-com-v1beta1-app,mutating=false,failurePolicy=fail,sideEffects=None,groups=ingress.zq.com,resources=apps,verbs=create;update;delete,versions=v1beta1,name=vapp.kb.io,admissionReviewVersions=v1
 
 var _ webhook.Validator = &App{}
 
@@ -64,7 +86,7 @@ func (r *App) ValidateCreate() (admission.Warnings, error) {
 
 	// TODO(user): fill in your validation logic upon object creation.
 
-	return r.validApp()
+	return r.validApp(nil)
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
@@ -73,7 +95,15 @@ func (r *App) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
 	applog.Info("validate update", "name", r.Name)
 
 	// TODO(user): fill in your validation logic upon object update.
-	return r.validApp()
+	oldApp, ok := old.(*App)
+	if !ok {
+		return nil, fmt.Errorf("expected an App but got a %T", old)
+	}
+	// App删除过程中controller需要移除finalizer，此时不再校验，避免删除被阻塞
+	if r.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	return r.validApp(oldApp)
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -81,15 +111,379 @@ func (r *App) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
 func (r *App) ValidateDelete() (admission.Warnings, error) {
 	applog.Info("validate delete", "name", r.Name)
 
-	// TODO(user): fill in your validation logic upon object deletion.
-	return nil, nil
+	return nil, r.validDelete(context.Background())
 }
 
-func (r *App) validApp() (admission.Warnings, error) {
-	if !*r.Spec.EnableSvc && *r.Spec.EnableIngress {
-		return nil, errors.NewInvalid(GroupVersion.WithKind("App").GroupKind(), r.Name, field.ErrorList{
-			field.Invalid(field.NewPath("enableSvc"), r.Spec.EnableSvc, "must enable svc before enable ingress"),
-		})
+// validApp 校验App，old为更新前的App，创建时为nil
+func (r *App) validApp(old *App) (admission.Warnings, error) {
+	var allErrs field.ErrorList
+	var warnings admission.Warnings
+	specPath := field.NewPath("spec")
+
+	// 镜像未修改时不再校验，避免之后新增的镜像策略拒绝已有App的其他更新
+	if old == nil || old.Spec.Image != r.Spec.Image {
+		imageWarnings, imageErrs := r.validImage(Images, specPath.Child("image"))
+		warnings = append(warnings, imageWarnings...)
+		allErrs = append(allErrs, imageErrs...)
+	}
+	if !ptr.Deref(r.Spec.EnableSvc, false) && ptr.Deref(r.Spec.EnableIngress, false) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("enableSvc"), r.Spec.EnableSvc, "must enable svc before enable ingress"))
+	}
+	allErrs = append(allErrs, validPorts(r.Spec.Ports, specPath.Child("ports"))...)
+	if r.Spec.Ingress != nil {
+		allErrs = append(allErrs, validIngress(r.Spec.Ingress, r.EffectivePorts(), specPath.Child("ingress"))...)
+	}
+	if r.Spec.ResourceProfile != "" {
+		if _, ok := Defaults.ResourceProfiles[r.Spec.ResourceProfile]; !ok {
+			allErrs = append(allErrs, field.NotSupported(specPath.Child("resourceProfile"), r.Spec.ResourceProfile, resourceProfileNames()))
+		}
+	} else {
+		// namespace选择了不存在的档位时，App仍然可以使用全局默认档位，只给出警告
+		ns, err := r.namespace(context.Background())
+		if err != nil {
+			return nil, errors.NewInternalError(err)
+		}
+		if _, err := r.resourceProfile(ns); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if r.Spec.Probes != nil {
+		allErrs = append(allErrs, validProbes(r.Spec.Probes, r.EffectivePorts(), specPath.Child("probes"))...)
+	}
+	if as := r.Spec.Autoscaling; as != nil && as.MinReplicas != nil && *as.MinReplicas > as.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("autoscaling", "maxReplicas"), as.MaxReplicas,
+			"must be greater than or equal to minReplicas"))
+	}
+	if rollout := r.Spec.Rollout; rollout != nil && rollout.Strategy == RolloutStrategyCanary && rollout.Canary == nil {
+		allErrs = append(allErrs, field.Required(specPath.Child("rollout", "canary"), "must be set for the Canary strategy"))
+	}
+	// 金丝雀按照权重拆分spec.replicas，HPA管理副本数时权重无法对应流量比例。已经同时设置的App不影响其他更新
+	if r.canaryAutoscaled() && (old == nil || !old.canaryAutoscaled()) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("rollout", "strategy"), "the Canary strategy cannot be combined with autoscaling"))
+	}
+	if revision, ok := r.Annotations[RollbackToAnnotation]; ok {
+		if n, err := strconv.ParseInt(revision, 10, 64); err != nil || n < 1 {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(RollbackToAnnotation), revision, "must be a revision number"))
+		}
+	}
+	if r.DisruptionBudgetEnabled() {
+		allErrs = append(allErrs, r.validDisruptionBudget(specPath)...)
+	}
+	for key := range r.Spec.Config {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("config").Key(key), key, msg))
+		}
+	}
+	if r.ingressEnabled() && appClient != nil && (old == nil || !old.ingressEnabled() || !slices.Equal(old.ingressRoutes(), r.ingressRoutes())) {
+		w, errs, err := r.validIngressConflicts(context.Background(), specPath.Child("ingress", "hosts"))
+		if err != nil {
+			return nil, errors.NewInternalError(err)
+		}
+		warnings = append(warnings, w...)
+		allErrs = append(allErrs, errs...)
+	}
+	// 规则可以约束spec、标签和注解，其余metadata的更新(例如controller添加finalizer)不受之后新增的AppPolicy影响
+	if appClient != nil && (old == nil || !equality.Semantic.DeepEqual(old.Spec, r.Spec) ||
+		!equality.Semantic.DeepEqual(old.Labels, r.Labels) || !equality.Semantic.DeepEqual(old.Annotations, r.Annotations)) {
+		errs, err := r.validPolicies(context.Background(), old)
+		if err != nil {
+			return nil, errors.NewInternalError(err)
+		}
+		allErrs = append(allErrs, errs...)
+	}
+
+	if len(allErrs) > 0 {
+		return warnings, errors.NewInvalid(GroupVersion.WithKind("App").GroupKind(), r.Name, allErrs)
+	}
+	return warnings, nil
+}
+
+// canaryAutoscaled reports whether the App combines the Canary strategy with autoscaling.
+func (r *App) canaryAutoscaled() bool {
+	return r.Spec.Rollout != nil && r.Spec.Rollout.Strategy == RolloutStrategyCanary && r.Spec.Autoscaling != nil
+}
+
+// ingressRoute 表示ingress中的一条host+path规则
+type ingressRoute struct {
+	host string
+	path string
+}
+
+// ingressEnabled reports whether the App is exposed through an Ingress.
+func (r *App) ingressEnabled() bool {
+	return ptr.Deref(r.Spec.EnableSvc, false) && ptr.Deref(r.Spec.EnableIngress, false)
+}
+
+func (r *App) ingressRoutes() []ingressRoute {
+	var routes []ingressRoute
+	for _, host := range r.IngressHosts() {
+		for _, p := range r.IngressPaths() {
+			routes = append(routes, ingressRoute{host: host, path: p.Path})
+		}
+	}
+	return routes
+}
+
+// validIngressConflicts 检查App的host+path是否已经被其他App或其创建的Ingress占用，
+// 不属于任何App的Ingress只产生警告
+func (r *App) validIngressConflicts(ctx context.Context, fldPath *field.Path) (admission.Warnings, field.ErrorList, error) {
+	self := types.NamespacedName{Namespace: r.Namespace, Name: r.Name}
+	owners := map[ingressRoute]string{}
+
+	apps := &AppList{}
+	if err := appClient.List(ctx, apps); err != nil {
+		return nil, nil, err
+	}
+	for i := range apps.Items {
+		other := &apps.Items[i]
+		key := types.NamespacedName{Namespace: other.Namespace, Name: other.Name}
+		if key == self || !other.ingressEnabled() {
+			continue
+		}
+		for _, route := range other.ingressRoutes() {
+			owners[route] = "App " + key.String()
+		}
+	}
+
+	ingresses := &netv1.IngressList{}
+	if err := appClient.List(ctx, ingresses); err != nil {
+		return nil, nil, err
+	}
+	unowned := map[ingressRoute]string{}
+	for _, ing := range ingresses.Items {
+		owner := "Ingress " + types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}.String()
+		ref := metav1.GetControllerOf(&ing)
+		isApp := ref != nil && ref.Kind == "App" && strings.HasPrefix(ref.APIVersion, GroupVersion.Group+"/")
+		if isApp {
+			key := types.NamespacedName{Namespace: ing.Namespace, Name: ref.Name}
+			if key == self {
+				continue
+			}
+			owner = "App " + key.String()
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				route := ingressRoute{host: rule.Host, path: p.Path}
+				if isApp {
+					owners[route] = owner
+				} else {
+					unowned[route] = owner
+				}
+			}
+		}
+	}
+
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	for _, route := range r.ingressRoutes() {
+		if owner, ok := owners[route]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath,
+				fmt.Sprintf("host %q path %q is already used by %s", route.host, route.path, owner)))
+		} else if owner, ok := unowned[route]; ok {
+			warnings = append(warnings, fmt.Sprintf("host %q path %q is also served by %s", route.host, route.path, owner))
+		}
+	}
+	return warnings, allErrs, nil
+}
+
+// validPorts 校验端口的名称、容器端口以及service端口不能重复
+func validPorts(ports []AppPort, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	containerPorts := map[string]bool{}
+	servicePorts := map[string]bool{}
+	for i, p := range ports {
+		idxPath := fldPath.Index(i)
+		if names[p.Name] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), p.Name))
+		}
+		names[p.Name] = true
+
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		key := fmt.Sprintf("%d/%s", p.ContainerPort, protocol)
+		if containerPorts[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("containerPort"), p.ContainerPort))
+		}
+		containerPorts[key] = true
+
+		servicePort := p.ServicePort
+		if servicePort == 0 {
+			servicePort = p.ContainerPort
+		}
+		key = fmt.Sprintf("%d/%s", servicePort, protocol)
+		if servicePorts[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("servicePort"), servicePort))
+		}
+		servicePorts[key] = true
+	}
+	return allErrs
+}
+
+// validDisruptionBudget 校验minAvailable和maxUnavailable只能设置一个，并且不能阻止所有pod被驱逐，
+// 否则节点将无法排空
+func (r *App) validDisruptionBudget(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	replicas := int(r.MinReplicas())
+	if r.Spec.MinAvailable != nil && r.Spec.MaxUnavailable != nil {
+		return append(allErrs, field.Forbidden(fldPath.Child("maxUnavailable"), "may not be set together with minAvailable"))
+	}
+	// 与disruption controller保持一致，百分比向上取整
+	if v := r.Spec.MinAvailable; v != nil {
+		minPath := fldPath.Child("minAvailable")
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(v, replicas, true)
+		switch {
+		case err != nil:
+			allErrs = append(allErrs, field.Invalid(minPath, v.String(), err.Error()))
+		case minAvailable < 0:
+			allErrs = append(allErrs, field.Invalid(minPath, v.String(), "must be greater than or equal to 0"))
+		case minAvailable >= replicas:
+			allErrs = append(allErrs, field.Invalid(minPath, v.String(),
+				fmt.Sprintf("must be less than the %d replicas the App may run with, otherwise no pod can be evicted", replicas)))
+		}
+	}
+	if v := r.Spec.MaxUnavailable; v != nil {
+		maxPath := fldPath.Child("maxUnavailable")
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(v, replicas, true)
+		switch {
+		case err != nil:
+			allErrs = append(allErrs, field.Invalid(maxPath, v.String(), err.Error()))
+		case maxUnavailable < 0:
+			allErrs = append(allErrs, field.Invalid(maxPath, v.String(), "must be greater than or equal to 0"))
+		case maxUnavailable == 0:
+			allErrs = append(allErrs, field.Invalid(maxPath, v.String(), "must be greater than 0, otherwise no pod can be evicted"))
+		}
+	}
+	return allErrs
+}
+
+// validProbes 校验探针必须且只能设置一种检查方式，并且探测的端口必须是已声明的端口
+func validProbes(probes *AppProbes, ports []AppPort, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if probes.HealthPath != "" && !strings.HasPrefix(probes.HealthPath, "/") {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("healthPath"), probes.HealthPath, "must be an absolute path"))
+	}
+
+	declared := func(port intstr.IntOrString) bool {
+		for _, p := range ports {
+			if (port.Type == intstr.String && port.StrVal == p.Name) ||
+				(port.Type == intstr.Int && port.IntVal == p.ContainerPort) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range []struct {
+		name  string
+		probe *corev1.Probe
+	}{
+		{"liveness", probes.Liveness},
+		{"readiness", probes.Readiness},
+		{"startup", probes.Startup},
+	} {
+		probe := p.probe
+		if probe == nil {
+			continue
+		}
+		probePath := fldPath.Child(p.name)
+		handlers := 0
+		if h := probe.HTTPGet; h != nil {
+			handlers++
+			if !declared(h.Port) {
+				allErrs = append(allErrs, field.NotFound(probePath.Child("httpGet", "port"), h.Port.String()))
+			}
+		}
+		if h := probe.TCPSocket; h != nil {
+			handlers++
+			if !declared(h.Port) {
+				allErrs = append(allErrs, field.NotFound(probePath.Child("tcpSocket", "port"), h.Port.String()))
+			}
+		}
+		if h := probe.GRPC; h != nil {
+			handlers++
+			if !declared(intstr.FromInt32(h.Port)) {
+				allErrs = append(allErrs, field.NotFound(probePath.Child("grpc", "port"), h.Port))
+			}
+		}
+		if probe.Exec != nil {
+			handlers++
+		}
+		if handlers != 1 {
+			allErrs = append(allErrs, field.Invalid(probePath, handlers, "must specify exactly one of httpGet, tcpSocket, grpc or exec"))
+		}
+	}
+	return allErrs
+}
+
+// validIngress 校验ingress的host必须为合法的DNS名称，path需要指向已声明的端口
+func validIngress(ing *AppIngress, ports []AppPort, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ing.IngressClassName != nil {
+		for _, msg := range validation.IsDNS1123Subdomain(*ing.IngressClassName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("ingressClassName"), *ing.IngressClassName, msg))
+		}
+	}
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(ing.Annotations, fldPath.Child("annotations"))...)
+
+	hosts := map[string]bool{}
+	for i, host := range ing.Hosts {
+		idxPath := fldPath.Child("hosts").Index(i)
+		allErrs = append(allErrs, validHost(host, idxPath)...)
+		if hosts[host] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, host))
+		}
+		hosts[host] = true
+	}
+
+	portNames := map[string]bool{}
+	for _, p := range ports {
+		portNames[p.Name] = true
+	}
+	paths := map[string]bool{}
+	for i, p := range ing.Paths {
+		idxPath := fldPath.Child("paths").Index(i)
+		if !strings.HasPrefix(p.Path, "/") {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("path"), p.Path, "must be an absolute path"))
+		}
+		if paths[p.Path] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("path"), p.Path))
+		}
+		paths[p.Path] = true
+		if p.Port != "" && !portNames[p.Port] {
+			allErrs = append(allErrs, field.NotFound(idxPath.Child("port"), p.Port))
+		}
+	}
+
+	for i, tls := range ing.TLS {
+		idxPath := fldPath.Child("tls").Index(i)
+		for j, host := range tls.Hosts {
+			allErrs = append(allErrs, validHost(host, idxPath.Child("hosts").Index(j))...)
+		}
+		if tls.SecretName != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(tls.SecretName) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("secretName"), tls.SecretName, msg))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validHost 校验host为合法的DNS名称，允许以*.开头的泛域名
+func validHost(host string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	var msgs []string
+	if strings.HasPrefix(host, "*.") {
+		msgs = validation.IsWildcardDNS1123Subdomain(host)
+	} else {
+		msgs = validation.IsDNS1123Subdomain(host)
+	}
+	for _, msg := range msgs {
+		allErrs = append(allErrs, field.Invalid(fldPath, host, msg))
 	}
-	return nil, nil
+	return allErrs
 }
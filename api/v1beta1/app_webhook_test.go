@@ -17,30 +17,556 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
 	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("App Webhook", func() {
 
 	Context("When creating App under Defaulting Webhook", func() {
 		It("Should fill in the default value if a required field is empty", func() {
+			orig := Defaults
+			Defaults = AppDefaults{
+				EnableSvc:       true,
+				EnableIngress:   true,
+				Replicas:        2,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Labels:          map[string]string{"team": "platform"},
+			}
+			DeferCleanup(func() { Defaults = orig })
+
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "default"},
+				Spec:       AppSpec{Image: "nginx:1.25"},
+			}
+			app.Default()
+			Expect(app.Spec.EnableSvc).To(Equal(ptr.To(true)))
+			Expect(app.Spec.EnableIngress).To(Equal(ptr.To(true)))
+			Expect(app.Spec.Replicas).To(Equal(ptr.To[int32](2)))
+			Expect(app.Spec.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+			Expect(app.Labels).To(HaveKeyWithValue("team", "platform"))
+
+			By("not changing the App when defaulted again")
+			defaulted := app.DeepCopy()
+			app.Default()
+			Expect(app).To(Equal(defaulted))
+
+			By("keeping values that are already set")
+			app = &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "explicit", Namespace: "default", Labels: map[string]string{"team": "web"}},
+				Spec: AppSpec{
+					EnableSvc:     ptr.To(false),
+					EnableIngress: ptr.To(false),
+					Replicas:      ptr.To[int32](0),
+					Image:         "nginx:1.25",
+				},
+			}
+			for i := 0; i < 2; i++ {
+				app.Default()
+				Expect(app.Spec.EnableSvc).To(Equal(ptr.To(false)))
+				Expect(app.Spec.EnableIngress).To(Equal(ptr.To(false)))
+				Expect(app.Spec.Replicas).To(Equal(ptr.To[int32](0)))
+				Expect(app.Labels).To(HaveKeyWithValue("team", "web"))
+			}
+
+			By("not enabling the ingress of an App without service")
+			app = &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "nosvc", Namespace: "default"},
+				Spec:       AppSpec{EnableSvc: ptr.To(false), Image: "nginx:1.25"},
+			}
+			app.Default()
+			Expect(app.Spec.EnableIngress).To(Equal(ptr.To(false)))
+			_, err := app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should load the defaults from a file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "defaults.yaml")
+			Expect(os.WriteFile(path, []byte("enableSvc: true\nimagePullPolicy: Always\n"), 0o644)).To(Succeed())
+			d, err := LoadAppDefaults(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.EnableSvc).To(BeTrue())
+			Expect(d.ImagePullPolicy).To(Equal(corev1.PullAlways))
+			Expect(d.Replicas).To(Equal(Defaults.Replicas))
+
+			Expect(os.WriteFile(path, []byte("imagePullPolicy: Sometimes\n"), 0o644)).To(Succeed())
+			_, err = LoadAppDefaults(path)
+			Expect(err).To(MatchError(ContainSubstring("imagePullPolicy")))
+
+			Expect(os.WriteFile(path, []byte("replica: 3\n"), 0o644)).To(Succeed())
+			_, err = LoadAppDefaults(path)
+			Expect(err).To(MatchError(ContainSubstring("unknown field")))
 
-			// TODO(user): Add your logic here
+			By("replacing the built-in resource profiles")
+			Expect(os.WriteFile(path, []byte("resourceProfiles:\n  tiny:\n    requests:\n      cpu: 50m\n"), 0o644)).To(Succeed())
+			d, err = LoadAppDefaults(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.ResourceProfiles).To(HaveLen(1))
+			Expect(d.ResourceProfiles).To(HaveKeyWithValue("tiny", corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+			}))
 
+			Expect(os.WriteFile(path, []byte("resourceProfiles:\n  tiny:\n    requests:\n      cpu: 2\n    limits:\n      cpu: 1\n"), 0o644)).To(Succeed())
+			_, err = LoadAppDefaults(path)
+			Expect(err).To(MatchError(ContainSubstring("resourceProfiles[tiny].requests[cpu]")))
+		})
+
+		It("Should fill omitted resources from the resource profile", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "resources", Namespace: "team-a"},
+				Spec: AppSpec{
+					EnableSvc:       ptr.To(true),
+					EnableIngress:   ptr.To(false),
+					Image:           "nginx:1.25",
+					ResourceProfile: "medium",
+					Resources: &corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+					},
+				},
+			}
+			app.Default()
+			medium := Defaults.ResourceProfiles["medium"]
+			Expect(app.Spec.Resources.Requests.Cpu().String()).To(Equal("2"))
+			Expect(app.Spec.Resources.Requests.Memory().Equal(*medium.Requests.Memory())).To(BeTrue())
+			// 补全的limit不能小于用户设置的request
+			Expect(app.Spec.Resources.Limits.Cpu().String()).To(Equal("2"))
+			Expect(app.Spec.Resources.Limits.Memory().Equal(*medium.Limits.Memory())).To(BeTrue())
+
+			By("using the profile of the namespace when the App does not select one")
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "team-a",
+					Annotations: map[string]string{ResourceProfileAnnotation: "large"},
+				},
+			}
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(AddToScheme(scheme)).To(Succeed())
+			orig := appClient
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ns).Build()
+			appClient = c
+			DeferCleanup(func() { appClient = orig })
+
+			app.Spec.ResourceProfile = ""
+			app.Spec.Resources = nil
+			app.Default()
+			Expect(equality.Semantic.DeepEqual(*app.Spec.Resources, Defaults.ResourceProfiles["large"])).To(BeTrue())
+
+			By("keeping the defaulted resources when the profile changes")
+			ns.Annotations[ResourceProfileAnnotation] = "small"
+			Expect(c.Update(context.Background(), ns)).To(Succeed())
+			app.Default()
+			Expect(equality.Semantic.DeepEqual(*app.Spec.Resources, Defaults.ResourceProfiles["large"])).To(BeTrue())
+
+			By("warning about unknown profiles selected by the namespace")
+			ns.Annotations[ResourceProfileAnnotation] = "huge"
+			Expect(c.Update(context.Background(), ns)).To(Succeed())
+			app.Spec.Resources = nil
+			app.Default()
+			Expect(equality.Semantic.DeepEqual(*app.Spec.Resources, Defaults.ResourceProfiles[DefaultResourceProfile])).To(BeTrue())
+			warnings, err := app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring(`unknown resource profile "huge"`)))
 		})
 	})
 
 	Context("When creating App under Validating Webhook", func() {
 		It("Should deny if a required field is empty", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "required", Namespace: "default"},
+				Spec:       AppSpec{EnableSvc: ptr.To(true)},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.image: Required value")))
+		})
+
+		It("Should admit if all required fields are provided", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "required", Namespace: "default"},
+				Spec: AppSpec{
+					EnableSvc: ptr.To(true),
+					Image:     "nginx:1.25",
+					Ports:     []AppPort{{Name: "http", ContainerPort: 8080, ServicePort: 80}},
+				},
+			}
+			warnings, err := app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
 
-			// TODO(user): Add your logic here
+		It("Should deny duplicate port names and numbers", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "ports", Namespace: "default"},
+				Spec: AppSpec{
+					EnableSvc: ptr.To(true),
+					Image:     "nginx:1.25",
+					Ports: []AppPort{
+						{Name: "http", ContainerPort: 8080},
+						{Name: "http", ContainerPort: 8080, ServicePort: 80},
+						{Name: "metrics", ContainerPort: 9090, ServicePort: 80},
+					},
+				},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.ports[1].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.ports[1].containerPort"))
+			Expect(err.Error()).To(ContainSubstring("spec.ports[2].servicePort"))
 
+			app.Spec.Ports = []AppPort{
+				{Name: "http", ContainerPort: 8080, ServicePort: 80},
+				{Name: "grpc", ContainerPort: 9090},
+			}
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
 		})
 
-		It("Should admit if all required fields are provided", func() {
+		It("Should deny ingress hosts that are not DNS names", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "hosts", Namespace: "default"},
+				Spec: AppSpec{
+					EnableSvc:     ptr.To(true),
+					EnableIngress: ptr.To(true),
+					Image:         "nginx:1.25",
+					Ingress: &AppIngress{
+						Hosts: []string{"app.example.com", "Not_A_Host", "*.example.com"},
+						Paths: []AppIngressPath{{Path: "/", Port: "grpc"}},
+					},
+				},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.ingress.hosts[1]"))
+			Expect(err.Error()).To(ContainSubstring("spec.ingress.paths[0].port"))
+
+			app.Spec.Ingress.Hosts = []string{"app.example.com", "*.example.com"}
+			app.Spec.Ingress.Paths[0].Port = ""
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny probes on undeclared ports", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "probes", Namespace: "default"},
+				Spec: AppSpec{
+					EnableSvc: ptr.To(true),
+					Image:     "nginx:1.25",
+					Ports:     []AppPort{{Name: "http", ContainerPort: 8080}},
+					Probes: &AppProbes{
+						HealthPath: "/healthz",
+						Liveness: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+							TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(9090)},
+						}},
+						Startup: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+							GRPC: &corev1.GRPCAction{Port: 8080},
+						}},
+					},
+				},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.probes.liveness.tcpSocket.port")))
+
+			app.Spec.Probes.Liveness.TCPSocket.Port = intstr.FromString("http")
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+
+			By("expanding healthPath into a readiness probe on the ingress port")
+			Expect(app.ReadinessProbe().HTTPGet.Path).To(Equal("/healthz"))
+			Expect(app.ReadinessProbe().HTTPGet.Port).To(Equal(intstr.FromString("http")))
+			Expect(app.LivenessProbe().TCPSocket).NotTo(BeNil())
+		})
+
+		It("Should deny autoscaling with maxReplicas below minReplicas", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "autoscaling", Namespace: "default"},
+				Spec: AppSpec{
+					Image:       "nginx:1.25",
+					Autoscaling: &AppAutoscaling{MinReplicas: ptr.To[int32](3), MaxReplicas: 2},
+				},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.autoscaling.maxReplicas")))
+
+			app.Spec.Autoscaling.MaxReplicas = 5
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+
+			By("defaulting to a CPU target when no metric is set")
+			metrics := app.AutoscalingMetrics()
+			Expect(metrics).To(HaveLen(1))
+			Expect(metrics[0].Resource.Name).To(Equal(corev1.ResourceCPU))
+			Expect(*metrics[0].Resource.Target.AverageUtilization).To(Equal(DefaultTargetCPUUtilizationPercentage))
+
+			By("denying canary rollouts of autoscaled Apps")
+			app.Spec.Rollout = &AppRollout{
+				Strategy: RolloutStrategyCanary,
+				Canary:   &AppCanary{Steps: []AppCanaryStep{{Weight: 50}}},
+			}
+			_, err = app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.rollout.strategy")))
+			Expect(app.CanaryEnabled()).To(BeFalse())
+			// 已经同时设置的App仍然可以进行其他更新
+			updated := app.DeepCopy()
+			updated.Spec.Image = "nginx:1.26"
+			_, err = updated.ValidateUpdate(app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should enforce the image policy", func() {
+			orig := Images
+			Images = ImagePolicy{
+				AllowedRegistries: []string{"registry.zq.com", "docker.io/library"},
+				ForbiddenTags:     []string{"latest"},
+				DigestNamespaces:  []string{"prod"},
+			}
+			DeferCleanup(func() { Images = orig })
+
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "image", Namespace: "default"},
+				Spec:       AppSpec{Image: "Not A Reference"},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.image")))
+
+			app.Spec.Image = "quay.io/team/app:1.0"
+			_, err = app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("not from an allowed registry")))
+
+			By("warning about forbidden tags")
+			app.Spec.Image = "nginx"
+			warnings, err := app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring(`forbidden tag "latest"`)))
+
+			By("requiring digests in the configured namespaces")
+			app.Namespace = "prod"
+			app.Spec.Image = "registry.zq.com/team/app:1.0"
+			_, err = app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("must be pinned by digest")))
+
+			app.Spec.Image = "registry.zq.com/team/app@sha256:" + strings.Repeat("a", 64)
+			warnings, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			By("not re-checking an unchanged image on update")
+			app.Spec.Image = "registry.zq.com/team/app:1.0"
+			updated := app.DeepCopy()
+			updated.Finalizers = []string{"ingress.zq.com/cleanup"}
+			_, err = updated.ValidateUpdate(app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should enforce the AppPolicies selecting the namespace", func() {
+			policy := &AppPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "production"},
+				Spec: AppPolicySpec{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+					Rules: []AppPolicyRule{
+						{Expression: "object.spec.replicas <= 5", Message: "at most 5 replicas"},
+						{Expression: "oldObject == null || object.spec.image == oldObject.spec.image"},
+					},
+				},
+			}
+			Expect(policy.ValidateCreate()).Error().NotTo(HaveOccurred())
+
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			orig := appClient
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"environment": "production"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev"}},
+			).Build()
+			appClient = c
+			DeferCleanup(func() { appClient = orig })
+
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "prod"},
+				Spec:       AppSpec{Image: "nginx:1.25", Replicas: ptr.To[int32](10)},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("at most 5 replicas")))
+
+			app.Namespace = "dev"
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+
+			By("evaluating rules against the old object on update")
+			app.Namespace = "prod"
+			app.Spec.Replicas = ptr.To[int32](3)
+			updated := app.DeepCopy()
+			updated.Spec.Image = "nginx:1.26"
+			_, err = updated.ValidateUpdate(app)
+			Expect(err).To(MatchError(ContainSubstring("failed rule: oldObject == null")))
+
+			By("skipping policies on updates that leave the spec, labels and annotations unchanged")
+			updated = app.DeepCopy()
+			updated.Finalizers = []string{"ingress.zq.com/cleanup"}
+			_, err = updated.ValidateUpdate(app)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("evaluating rules on updates that only change labels")
+			policy.Spec.Rules = []AppPolicyRule{{
+				Expression: "has(object.metadata.labels) && 'team' in object.metadata.labels",
+				Message:    "the team label is required",
+			}}
+			Expect(c.Update(context.Background(), policy)).To(Succeed())
+			app.Labels = map[string]string{"team": "payments"}
+			updated = app.DeepCopy()
+			updated.Labels = nil
+			_, err = updated.ValidateUpdate(app)
+			Expect(err).To(MatchError(ContainSubstring("the team label is required")))
+
+			By("rejecting policies that do not compile")
+			policy.Spec.Rules = []AppPolicyRule{{Expression: "object.spec.replicas <="}}
+			Expect(policy.ValidateCreate()).Error().To(MatchError(ContainSubstring("spec.rules[0].expression")))
+		})
+
+		It("Should deny deleting protected Apps without the override annotation", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			orig, origNamespaces := appClient, ProtectedNamespaces
+			appClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "payments",
+					Annotations: map[string]string{DeleteProtectionAnnotation: "true"},
+				},
+			}).Build()
+			ProtectedNamespaces = []string{"prod"}
+			DeferCleanup(func() { appClient, ProtectedNamespaces = orig, origNamespaces })
+
+			app := &App{ObjectMeta: metav1.ObjectMeta{Name: "protected", Namespace: "default"}}
+			Expect(app.ValidateDelete()).Error().NotTo(HaveOccurred())
+
+			app.Annotations = map[string]string{DeleteProtectionAnnotation: "true"}
+			Expect(app.ValidateDelete()).Error().To(MatchError(ContainSubstring(AllowDeleteAnnotation)))
+
+			app.Annotations[AllowDeleteAnnotation] = "true"
+			Expect(app.ValidateDelete()).Error().NotTo(HaveOccurred())
+
+			By("protecting every App in protected namespaces")
+			for _, ns := range []string{"prod", "payments"} {
+				app := &App{ObjectMeta: metav1.ObjectMeta{Name: "protected", Namespace: ns}}
+				Expect(app.ValidateDelete()).Error().To(MatchError(ContainSubstring("namespace " + ns)))
+			}
+		})
+
+		It("Should deny rolling back to something that is not a revision number", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "rollback",
+					Namespace:   "default",
+					Annotations: map[string]string{RollbackToAnnotation: "latest"},
+				},
+				Spec: AppSpec{Image: "nginx:1.25"},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("metadata.annotations[ingress.zq.com/rollback-to]")))
+
+			app.Annotations[RollbackToAnnotation] = "3"
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny disruption budgets that block all evictions", func() {
+			app := &App{
+				ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: "default"},
+				Spec: AppSpec{
+					Image:        "nginx:1.25",
+					Replicas:     ptr.To[int32](3),
+					MinAvailable: ptr.To(intstr.FromInt32(3)),
+				},
+			}
+			_, err := app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.minAvailable")))
+
+			app.Spec.MinAvailable = ptr.To(intstr.FromString("50%"))
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking against the autoscaler minimum when autoscaling")
+			app.Spec.Autoscaling = &AppAutoscaling{MaxReplicas: 5}
+			_, err = app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.minAvailable")))
+
+			app.Spec.MinAvailable = nil
+			app.Spec.MaxUnavailable = ptr.To(intstr.FromString("0%"))
+			_, err = app.ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("spec.maxUnavailable")))
+
+			app.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(1))
+			_, err = app.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny an ingress host and path already used by another App", func() {
+			newApp := func(name, namespace, host string) *App {
+				return &App{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+					Spec: AppSpec{
+						EnableSvc:     ptr.To(true),
+						EnableIngress: ptr.To(true),
+						Image:         "nginx:1.25",
+						Ingress:       &AppIngress{Hosts: []string{host}},
+					},
+				}
+			}
+			owner := newApp("owner", "team-a", "shared.example.com")
+			prefix := netv1.PathTypePrefix
+			legacy := &netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "team-c"},
+				Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{{
+					Host: "legacy.example.com",
+					IngressRuleValue: netv1.IngressRuleValue{HTTP: &netv1.HTTPIngressRuleValue{
+						Paths: []netv1.HTTPIngressPath{{Path: "/", PathType: &prefix}},
+					}},
+				}}},
+			}
+
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			orig := appClient
+			appClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner, legacy).Build()
+			DeferCleanup(func() { appClient = orig })
+
+			_, err := newApp("intruder", "team-b", "shared.example.com").ValidateCreate()
+			Expect(err).To(MatchError(ContainSubstring("already used by App team-a/owner")))
+
+			// 同一个App更新时不应与自己冲突
+			_, err = owner.ValidateUpdate(owner)
+			Expect(err).NotTo(HaveOccurred())
 
-			// TODO(user): Add your logic here
+			// 已经存在的冲突只在修改host或path时报告，不阻止App的其他更新
+			twin := newApp("twin", "team-b", "shared.example.com")
+			updated := twin.DeepCopy()
+			updated.Spec.Image = "nginx:1.26"
+			_, err = updated.ValidateUpdate(twin)
+			Expect(err).NotTo(HaveOccurred())
+			updated.Spec.Ingress.Hosts = append(updated.Spec.Ingress.Hosts, "twin.example.com")
+			_, err = updated.ValidateUpdate(twin)
+			Expect(err).To(MatchError(ContainSubstring("already used by App team-a/owner")))
 
+			// 不属于任何App的Ingress只产生警告
+			warnings, err := newApp("migrated", "team-c", "legacy.example.com").ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring("Ingress team-c/legacy")))
 		})
 	})
 
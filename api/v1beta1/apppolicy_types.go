@@ -0,0 +1,72 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AppPolicySpec defines the rules an AppPolicy enforces on Apps.
+type AppPolicySpec struct {
+	// NamespaceSelector selects the namespaces whose Apps the policy applies
+	// to. An empty or omitted selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Rules every selected App must satisfy on create and update.
+	// +kubebuilder:validation:MinItems=1
+	Rules []AppPolicyRule `json:"rules"`
+}
+
+// AppPolicyRule is a CEL validation rule evaluated against an App.
+type AppPolicyRule struct {
+	// Expression is a CEL expression that must evaluate to true for the App
+	// to be admitted. The App is available as object and, on update, the
+	// previous App as oldObject, which is null on create.
+	// e.g. "object.spec.replicas <= 5" or "'team' in object.metadata.labels".
+	// +kubebuilder:validation:MinLength=1
+	Expression string `json:"expression"`
+	// Message returned when the expression evaluates to false. Defaults to
+	// the failed expression.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// AppPolicy is the Schema for the apppolicies API. It holds validation rules
+// the App webhook enforces in the namespaces it selects.
+type AppPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AppPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AppPolicyList contains a list of AppPolicy
+type AppPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AppPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AppPolicy{}, &AppPolicyList{})
+}
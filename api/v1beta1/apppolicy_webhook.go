@@ -0,0 +1,250 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// policyCostLimit 限制单条CEL表达式的求值开销，避免代价过高的规则拖慢准入请求
+const policyCostLimit = 1000000
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks
+func (r *AppPolicy) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// App的validating webhook需要读取AppPolicy
+//+kubebuilder:rbac:groups=ingress.zq.com,resources=apppolicies,verbs=get;list;watch
+
+//+kubebuilder:webhook:path=/validate-ingress-zq-com-v1beta1-apppolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=ingress.zq.com,resources=apppolicies,verbs=create;update,versions=v1beta1,name=vapppolicy.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &AppPolicy{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+// 创建AppPolicy时编译其中的表达式，有错误的规则在创建时就被拒绝，而不是在App准入时才发现
+func (r *AppPolicy) ValidateCreate() (admission.Warnings, error) {
+	return nil, r.validPolicy()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *AppPolicy) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	return nil, r.validPolicy()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *AppPolicy) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *AppPolicy) validPolicy() error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	if r.Spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(r.Spec.NamespaceSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("namespaceSelector"), r.Spec.NamespaceSelector, err.Error()))
+		}
+	}
+	for i, rule := range r.Spec.Rules {
+		if _, err := compileRule(rule.Expression); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("rules").Index(i).Child("expression"), rule.Expression, err.Error()))
+		}
+	}
+	if len(allErrs) > 0 {
+		return errors.NewInvalid(GroupVersion.WithKind("AppPolicy").GroupKind(), r.Name, allErrs)
+	}
+	return nil
+}
+
+// policyEnv 是编译规则使用的CEL环境，object和oldObject为App转换成的map
+var policyEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		ext.Strings(),
+	)
+})
+
+// compiledRule 是编译后的规则，编译失败时保存错误
+type compiledRule struct {
+	expression string
+	program    cel.Program
+	err        error
+}
+
+// compileRule compiles a rule expression into a program returning a bool.
+func compileRule(expression string) (cel.Program, error) {
+	env, err := policyEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expression)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("must evaluate to a bool, got %s", t)
+	}
+	return env.Program(ast, cel.CostLimit(policyCostLimit))
+}
+
+// compiledPolicy 是某个AppPolicy的某个generation编译后的规则
+type compiledPolicy struct {
+	uid        types.UID
+	generation int64
+	rules      []compiledRule
+}
+
+// policyPrograms 缓存每个AppPolicy编译后的规则，key为AppPolicy的名称，
+// AppPolicy被修改后重新编译，被删除后在下一次校验App时移除，缓存大小不超过AppPolicy的数量
+var policyPrograms = struct {
+	sync.Mutex
+	policies map[string]*compiledPolicy
+}{policies: map[string]*compiledPolicy{}}
+
+// compilePolicies returns the compiled rules of the policies, compiling only
+// the policies that changed since they were last seen, and evicts the rules
+// of policies that no longer exist.
+func compilePolicies(policies []AppPolicy) map[string][]compiledRule {
+	policyPrograms.Lock()
+	defer policyPrograms.Unlock()
+
+	out := make(map[string][]compiledRule, len(policies))
+	for _, policy := range policies {
+		cached, ok := policyPrograms.policies[policy.Name]
+		if !ok || cached.uid != policy.UID || cached.generation != policy.Generation || !sameExpressions(cached.rules, policy.Spec.Rules) {
+			cached = &compiledPolicy{uid: policy.UID, generation: policy.Generation}
+			for _, rule := range policy.Spec.Rules {
+				c := compiledRule{expression: rule.Expression}
+				c.program, c.err = compileRule(rule.Expression)
+				cached.rules = append(cached.rules, c)
+			}
+			policyPrograms.policies[policy.Name] = cached
+		}
+		out[policy.Name] = cached.rules
+	}
+	for name := range policyPrograms.policies {
+		if _, ok := out[name]; !ok {
+			delete(policyPrograms.policies, name)
+		}
+	}
+	return out
+}
+
+// sameExpressions reports whether the compiled rules were compiled from the expressions of rules.
+func sameExpressions(compiled []compiledRule, rules []AppPolicyRule) bool {
+	if len(compiled) != len(rules) {
+		return false
+	}
+	for i := range rules {
+		if compiled[i].expression != rules[i].Expression {
+			return false
+		}
+	}
+	return true
+}
+
+// validPolicies 使用App所在namespace匹配的AppPolicy校验App，old为更新前的App，创建时为nil
+func (r *App) validPolicies(ctx context.Context, old *App) (field.ErrorList, error) {
+	policies := &AppPolicyList{}
+	if err := appClient.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+	sort.Slice(policies.Items, func(i, j int) bool { return policies.Items[i].Name < policies.Items[j].Name })
+
+	ns := &corev1.Namespace{}
+	if err := appClient.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{"oldObject": nil}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil, err
+	}
+	vars["object"] = object
+	if old != nil {
+		if vars["oldObject"], err = runtime.DefaultUnstructuredConverter.ToUnstructured(old); err != nil {
+			return nil, err
+		}
+	}
+
+	programs := compilePolicies(policies.Items)
+	var allErrs field.ErrorList
+	for _, policy := range policies.Items {
+		policyPath := field.NewPath("appPolicy").Key(policy.Name)
+		selector := labels.Everything()
+		if policy.Spec.NamespaceSelector != nil {
+			if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector); err != nil {
+				allErrs = append(allErrs, field.Invalid(policyPath.Child("namespaceSelector"), policy.Spec.NamespaceSelector, err.Error()))
+				continue
+			}
+		}
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+
+		for i, rule := range policy.Spec.Rules {
+			rulePath := policyPath.Child("rules").Index(i)
+			compiled := programs[policy.Name][i]
+			if compiled.err != nil {
+				allErrs = append(allErrs, field.Invalid(rulePath.Child("expression"), rule.Expression, compiled.err.Error()))
+				continue
+			}
+			// 求值失败时拒绝App，避免有问题的规则被绕过
+			out, _, err := compiled.program.ContextEval(ctx, vars)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(rulePath.Child("expression"), rule.Expression, "evaluation failed: "+err.Error()))
+				continue
+			}
+			ok, isBool := out.Value().(bool)
+			switch {
+			case !isBool:
+				allErrs = append(allErrs, field.Invalid(rulePath.Child("expression"), rule.Expression, "must evaluate to a bool"))
+			case !ok:
+				msg := rule.Message
+				if msg == "" {
+					msg = fmt.Sprintf("failed rule: %s", rule.Expression)
+				}
+				allErrs = append(allErrs, field.Forbidden(rulePath, msg))
+			}
+		}
+	}
+	return allErrs, nil
+}
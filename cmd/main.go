@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,8 +35,10 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	ingressv1 "github.com/hdssbks/kubebuilder-demo/api/v1"
 	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
 	"github.com/hdssbks/kubebuilder-demo/internal/controller"
+	"github.com/hdssbks/kubebuilder-demo/utils"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,6 +52,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(ingressv1beta1.AddToScheme(scheme))
+	utilruntime.Must(ingressv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -58,6 +62,12 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var templateDir string
+	var defaultResourceProfile string
+	var appDefaultsFile string
+	var imagePolicyFile string
+	var protectedNamespaces string
+	var pauseReconciliation bool
 	// 定义命令行参数，使用方法./manager --metrics-bind-address=:8080 --leader-elect=true
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -68,6 +78,18 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&templateDir, "template-dir", "",
+		"If set, templates found in this directory override the ones compiled into the binary")
+	flag.StringVar(&defaultResourceProfile, "default-resource-profile", ingressv1beta1.DefaultResourceProfile,
+		"The resource profile used for Apps that neither select a profile nor are in a namespace selecting one")
+	flag.StringVar(&appDefaultsFile, "app-defaults-file", "",
+		"If set, a YAML file with the defaults the webhook applies to Apps, e.g. enableSvc, replicas, imagePullPolicy and labels")
+	flag.StringVar(&imagePolicyFile, "image-policy-file", "",
+		"If set, a YAML file with the allowed registries, forbidden tags and digest requirements enforced on App images")
+	flag.StringVar(&protectedNamespaces, "protected-namespaces", "",
+		"Comma-separated namespaces whose Apps can only be deleted after being annotated with "+ingressv1beta1.AllowDeleteAnnotation+"=true")
+	flag.BoolVar(&pauseReconciliation, "pause-reconciliation", false,
+		"If set, the child resources of Apps are left untouched and only their status is updated")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -76,6 +98,38 @@ func main() {
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	utils.SetTemplateDir(templateDir)
+	ingressv1beta1.DefaultResourceProfile = defaultResourceProfile
+	if appDefaultsFile != "" {
+		defaults, err := ingressv1beta1.LoadAppDefaults(appDefaultsFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load app defaults", "file", appDefaultsFile)
+			os.Exit(1)
+		}
+		ingressv1beta1.Defaults = defaults
+	}
+	if _, ok := ingressv1beta1.Defaults.ResourceProfiles[defaultResourceProfile]; defaultResourceProfile != "" && !ok {
+		setupLog.Error(nil, "the default resource profile is not configured", "profile", defaultResourceProfile)
+		os.Exit(1)
+	}
+	if imagePolicyFile != "" {
+		policy, err := ingressv1beta1.LoadImagePolicy(imagePolicyFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load image policy", "file", imagePolicyFile)
+			os.Exit(1)
+		}
+		ingressv1beta1.Images = policy
+	}
+	if protectedNamespaces != "" {
+		var namespaces []string
+		for _, ns := range strings.Split(protectedNamespaces, ",") {
+			// "a, b," 这类写法里的空格和空项不是有效的 namespace
+			if ns = strings.TrimSpace(ns); ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		ingressv1beta1.ProtectedNamespaces = namespaces
+	}
 
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
@@ -141,6 +195,7 @@ func main() {
 		Client:   mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("app-controller"),
+		Paused:   pauseReconciliation,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "App")
 		os.Exit(1)
@@ -151,6 +206,18 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&ingressv1beta1.AppPolicy{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AppPolicy")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&ingressv1.App{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "App")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
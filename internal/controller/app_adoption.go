@@ -0,0 +1,93 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// Reasons reported in the Adopted condition.
+const (
+	ReasonAdoptionDisabled  = "AdoptionDisabled"
+	ReasonControlledByOther = "ControlledByOther"
+	ReasonChildrenAdopted   = "ChildrenAdopted"
+)
+
+// adoptionError reports an existing child resource the App may not take over.
+type adoptionError struct {
+	reason  string
+	message string
+}
+
+func (e *adoptionError) Error() string { return e.message }
+
+// adopt decides whether the existing object live, which is not controlled by
+// the App, may be taken over under the adoption policy of the App. With the
+// Force policy the current controller reference is removed from the object.
+func (r *AppReconciler) adopt(ctx context.Context, app *ingressv1beta1.App, live client.Object) error {
+	kind := live.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(live, r.Scheme); err == nil {
+		kind = gvk.Kind
+	}
+
+	policy := app.Spec.AdoptionPolicy
+	if policy == "" {
+		policy = ingressv1beta1.AdoptionPolicyIfUnowned
+	}
+	owner := metav1.GetControllerOf(live)
+
+	var adoptErr *adoptionError
+	switch {
+	case policy == ingressv1beta1.AdoptionPolicyNever:
+		adoptErr = &adoptionError{reason: ReasonAdoptionDisabled,
+			message: fmt.Sprintf("%s %s already exists and adoptionPolicy is Never", kind, live.GetName())}
+	case owner != nil && policy != ingressv1beta1.AdoptionPolicyForce:
+		adoptErr = &adoptionError{reason: ReasonControlledByOther,
+			message: fmt.Sprintf("%s %s is controlled by %s %s", kind, live.GetName(), owner.Kind, owner.Name)}
+	}
+	if adoptErr != nil {
+		r.Recorder.Event(app, corev1.EventTypeWarning, "AdoptionFailed", adoptErr.message)
+		return withReason(adoptErr.reason, adoptErr)
+	}
+
+	message := fmt.Sprintf("Adopted %s %s", kind, live.GetName())
+	if owner != nil {
+		// 一个对象只能有一个controller，先去掉原controller的ownerReference
+		patch := client.MergeFrom(live.DeepCopyObject().(client.Object))
+		var refs []metav1.OwnerReference
+		for _, ref := range live.GetOwnerReferences() {
+			if ref.UID != owner.UID {
+				refs = append(refs, ref)
+			}
+		}
+		live.SetOwnerReferences(refs)
+		if err := r.Patch(ctx, live, patch); err != nil {
+			return err
+		}
+		message += fmt.Sprintf(" from %s %s", owner.Kind, owner.Name)
+	}
+	r.Recorder.Event(app, corev1.EventTypeNormal, "Adopted", message)
+	return nil
+}
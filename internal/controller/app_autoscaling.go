@@ -0,0 +1,73 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// replicasHandoverManager 在开启自动扩缩容时接管deployment的spec.replicas，
+// 之后该字段只由HPA修改
+const replicasHandoverManager = FieldManager + "-handover"
+
+// handOverReplicas keeps spec.replicas of the Deployment when the controller
+// stops rendering it because autoscaling is enabled. Server-side apply removes
+// fields a manager stops applying when nobody else owns them, which would
+// scale the Deployment back to its default of one replica, so the live value
+// is first applied by a separate field manager.
+func (r *AppReconciler) handOverReplicas(ctx context.Context, key client.ObjectKey) error {
+	live := &appv1.Deployment{}
+	if err := r.Get(ctx, key, live); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if live.Spec.Replicas == nil || !ownsField(live, FieldManager, "f:spec", "f:replicas") {
+		return nil
+	}
+
+	patch := &unstructured.Unstructured{}
+	patch.SetAPIVersion(appv1.SchemeGroupVersion.String())
+	patch.SetKind("Deployment")
+	patch.SetNamespace(live.Namespace)
+	patch.SetName(live.Name)
+	if err := unstructured.SetNestedField(patch.Object, int64(*live.Spec.Replicas), "spec", "replicas"); err != nil {
+		return err
+	}
+	return r.Patch(ctx, patch, client.Apply, client.FieldOwner(replicasHandoverManager), client.ForceOwnership)
+}
+
+// ownsField reports whether manager applied the field at path of obj.
+func ownsField(obj metav1.Object, manager string, path ...string) bool {
+	for _, mf := range obj.GetManagedFields() {
+		if mf.Manager != manager || mf.Operation != metav1.ManagedFieldsOperationApply || mf.FieldsV1 == nil {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(mf.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, found, _ := unstructured.NestedFieldNoCopy(fields, path...); found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+	"github.com/hdssbks/kubebuilder-demo/utils"
+)
+
+// colorLabel 标记蓝绿发布中pod所属的颜色
+const colorLabel = "color"
+
+// otherColor returns the colour that is not color.
+func otherColor(color string) string {
+	if color == ingressv1beta1.ColorBlue {
+		return ingressv1beta1.ColorGreen
+	}
+	return ingressv1beta1.ColorBlue
+}
+
+// colorSelector returns the selector of the App's pods of the given colour.
+func colorSelector(app *ingressv1beta1.App, color string) map[string]string {
+	return map[string]string{"app": app.Name, colorLabel: color}
+}
+
+// reconcileBlueGreen drives the blue/green rollout of the App's image. The
+// active colour runs the stable image, the other colour is scaled up with a
+// new image and becomes active once it is available and, with manual
+// promotion, once the App is annotated for promotion. svcOpts is set to make
+// the Service select the active colour.
+func (r *AppReconciler) reconcileBlueGreen(ctx context.Context, app *ingressv1beta1.App, opts utils.DeployOptions, svcOpts *utils.ServiceOptions, report *reconcileReport) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	canaryKey := types.NamespacedName{Namespace: app.Namespace, Name: app.CanaryName()}
+	if err := r.deleteResource(ctx, app, canaryKey, &appv1.Deployment{}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	plain, err := r.getDeployment(ctx, client.ObjectKeyFromObject(app))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status := &ingressv1beta1.AppRolloutStatus{}
+	if app.Status.Rollout != nil {
+		status = app.Status.Rollout.DeepCopy()
+	}
+	report.rollout = status
+	// 首次开启蓝绿发布时，当前版本作为蓝色生效
+	if status.ActiveColor == "" {
+		*status = ingressv1beta1.AppRolloutStatus{ActiveColor: ingressv1beta1.ColorBlue, StableImage: app.Spec.Image}
+		if image := containerImage(plain); image != "" {
+			status.StableImage = image
+		}
+	}
+	active, preview := status.ActiveColor, otherColor(status.ActiveColor)
+	activeLive, err := r.getDeployment(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.ColorDeploymentName(active)})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	previewLive, err := r.getDeployment(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.ColorDeploymentName(preview)})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 新颜色的副本数与生效颜色相同，开启自动扩缩容时以HPA当前的副本数为准
+	replicas := ptr.Deref(app.Spec.Replicas, 1)
+	if app.Spec.Autoscaling != nil {
+		replicas = app.MinReplicas()
+		if activeLive != nil && activeLive.Spec.Replicas != nil {
+			replicas = *activeLive.Spec.Replicas
+		}
+	}
+
+	var result ctrl.Result
+	// previewImage为空时不创建预览颜色的Deployment
+	previewImage, previewReplicas := containerImage(previewLive), int32(0)
+	if app.Spec.Image == status.StableImage {
+		if status.Phase != ingressv1beta1.RolloutPromoted {
+			*status = ingressv1beta1.AppRolloutStatus{ActiveColor: active, StableImage: status.StableImage}
+		}
+	} else {
+		if status.PreviewImage != app.Spec.Image {
+			*status = ingressv1beta1.AppRolloutStatus{
+				Phase:        ingressv1beta1.RolloutProgressing,
+				ActiveColor:  active,
+				StableImage:  status.StableImage,
+				PreviewImage: app.Spec.Image,
+			}
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, "BlueGreenStarted", "Started rollout of %s to %s", app.Spec.Image, preview)
+		}
+		previewImage, previewReplicas = app.Spec.Image, replicas
+
+		manual := app.Spec.Rollout.BlueGreen != nil && app.Spec.Rollout.BlueGreen.ManualPromotion
+		switch {
+		case !deploymentReady(previewLive, replicas) || containerImage(previewLive) != app.Spec.Image:
+			status.Phase = ingressv1beta1.RolloutProgressing
+			status.Message = fmt.Sprintf("waiting for %d replicas of %s to become ready", replicas, preview)
+		case manual && app.Annotations[ingressv1beta1.PromoteAnnotation] != "true":
+			status.Phase = ingressv1beta1.RolloutPaused
+			status.Message = fmt.Sprintf("%s is ready, waiting for the %s annotation", preview, ingressv1beta1.PromoteAnnotation)
+		default:
+			// 切换生效的颜色，原生效颜色缩容到0，保留其镜像
+			previewImage = status.StableImage
+			*status = ingressv1beta1.AppRolloutStatus{
+				Phase:       ingressv1beta1.RolloutPromoted,
+				ActiveColor: preview,
+				StableImage: app.Spec.Image,
+				Message:     fmt.Sprintf("promoted %s to %s", app.Spec.Image, preview),
+			}
+			active, preview = preview, active
+			activeLive = previewLive
+			previewReplicas = 0
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, "BlueGreenPromoted", "Switched the service to %s running %s", active, app.Spec.Image)
+			if _, ok := app.Annotations[ingressv1beta1.PromoteAnnotation]; ok {
+				patch := client.MergeFrom(app.DeepCopy())
+				delete(app.Annotations, ingressv1beta1.PromoteAnnotation)
+				if err := r.Patch(ctx, app, patch); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			// HPA的scaleTargetRef依赖status中生效的颜色，status写入后再次Reconcile
+			result = ctrl.Result{Requeue: true}
+		}
+	}
+
+	activeOpts := opts
+	activeOpts.Name = app.ColorDeploymentName(active)
+	activeOpts.Image = status.StableImage
+	activeOpts.Labels = map[string]string{colorLabel: active}
+	if app.Spec.Autoscaling != nil {
+		if err := r.handOverReplicas(ctx, types.NamespacedName{Namespace: app.Namespace, Name: activeOpts.Name}); err != nil {
+			logger.Error(err, "hand over deployment replicas failed")
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.applyColor(ctx, app, activeOpts, report); err != nil {
+		return ctrl.Result{}, err
+	}
+	if previewImage != "" {
+		previewOpts := opts
+		previewOpts.Name = app.ColorDeploymentName(preview)
+		previewOpts.Image = previewImage
+		previewOpts.Labels = map[string]string{colorLabel: preview}
+		previewOpts.Replicas = ptr.To(previewReplicas)
+		if err := r.applyColor(ctx, app, previewOpts, report); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 从滚动更新切换而来时，原Deployment在生效颜色可用之前继续提供服务，Service选中App的所有pod
+	if plain != nil {
+		if !deploymentReady(activeLive, replicas) {
+			return result, nil
+		}
+		if err := r.deleteResource(ctx, app, client.ObjectKeyFromObject(plain), &appv1.Deployment{}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	svcOpts.Selector = colorSelector(app, active)
+	return result, nil
+}
+
+// applyColor applies the Deployment of a colour rendered with opts.
+func (r *AppReconciler) applyColor(ctx context.Context, app *ingressv1beta1.App, opts utils.DeployOptions, report *reconcileReport) error {
+	deploy, err := utils.NewDeploy(app, opts)
+	if err != nil {
+		return r.renderFailed(ctx, app, opts.Name+" deployment", err)
+	}
+	if _, err := r.applyResource(ctx, app, deploy, report); err != nil {
+		log.FromContext(ctx).Error(err, "apply "+opts.Name+" deployment failed")
+		r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyDeploymentFailed", err.Error())
+		return err
+	}
+	return nil
+}
+
+// getDeployment returns the Deployment with the given key, or nil if it does not exist.
+func (r *AppReconciler) getDeployment(ctx context.Context, key client.ObjectKey) (*appv1.Deployment, error) {
+	deploy := &appv1.Deployment{}
+	if err := r.Get(ctx, key, deploy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return deploy, nil
+}
+
+// containerImage returns the image of the container of the Deployment.
+func containerImage(deploy *appv1.Deployment) string {
+	if deploy == nil || len(deploy.Spec.Template.Spec.Containers) == 0 {
+		return ""
+	}
+	return deploy.Spec.Template.Spec.Containers[0].Image
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// configRefIndex 是App上的索引，值为App引用的ConfigMap和Secret，形如configmap/<name>、secret/<name>
+const configRefIndex = ".spec.configRefs"
+
+// configRefs returns the ConfigMaps and Secrets referenced by the container of the App.
+func configRefs(app *ingressv1beta1.App) []string {
+	seen := map[string]bool{}
+	var refs []string
+	add := func(kind, name string) {
+		ref := kind + "/" + name
+		if name != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	for _, env := range app.Spec.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+			add("configmap", ref.Name)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+			add("secret", ref.Name)
+		}
+	}
+	for _, envFrom := range app.Spec.EnvFrom {
+		if ref := envFrom.ConfigMapRef; ref != nil {
+			add("configmap", ref.Name)
+		}
+		if ref := envFrom.SecretRef; ref != nil {
+			add("secret", ref.Name)
+		}
+	}
+	return refs
+}
+
+// configChecksum hashes the inline config of the App together with the data of
+// every ConfigMap it references. The data of Secrets is never read: a Secret
+// hashes as its checksum annotation, or as its resourceVersion without one.
+// Missing references hash as empty.
+func (r *AppReconciler) configChecksum(ctx context.Context, app *ingressv1beta1.App) (string, error) {
+	refs := configRefs(app)
+	if len(refs) == 0 && len(app.Spec.Config) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	writeData := func(prefix string, data map[string][]byte) {
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s/%s=%x\n", prefix, k, sha256.Sum256(data[k]))
+		}
+	}
+
+	inline := map[string][]byte{}
+	for k, v := range app.Spec.Config {
+		inline[k] = []byte(v)
+	}
+	writeData("inline", inline)
+
+	for _, ref := range refs {
+		kind, name, _ := strings.Cut(ref, "/")
+		key := types.NamespacedName{Namespace: app.Namespace, Name: name}
+		data := map[string][]byte{}
+		switch kind {
+		case "configmap":
+			cm := &corev1.ConfigMap{}
+			if err := r.Get(ctx, key, cm); err != nil && !errors.IsNotFound(err) {
+				return "", err
+			}
+			for k, v := range cm.Data {
+				data[k] = []byte(v)
+			}
+			for k, v := range cm.BinaryData {
+				data[k] = v
+			}
+		case "secret":
+			// Secret只以metadata的形式缓存，controller不读取其中的数据。优先使用Secret所有者维护的checksum注解，
+			// 没有注解时以resourceVersion代替，此时Secret的labels、annotations变化也会重启Pod
+			secret := &metav1.PartialObjectMetadata{}
+			secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+			if err := r.Get(ctx, key, secret); err != nil && !errors.IsNotFound(err) {
+				return "", err
+			}
+			if sum, ok := secret.Annotations[ingressv1beta1.ChecksumAnnotation]; ok {
+				data["checksum"] = []byte(sum)
+			} else if secret.ResourceVersion != "" {
+				data["resourceVersion"] = []byte(secret.ResourceVersion)
+			}
+		}
+		writeData(ref, data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// appsForConfig 在被引用的ConfigMap或Secret变化时，找到引用它的App并触发Reconcile
+func (r *AppReconciler) appsForConfig(kind string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		apps := &ingressv1beta1.AppList{}
+		if err := r.List(ctx, apps, client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{configRefIndex: kind + "/" + obj.GetName()}); err != nil {
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(apps.Items))
+		for _, app := range apps.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name},
+			})
+		}
+		return requests
+	}
+}
@@ -20,34 +20,56 @@ import (
 	"context"
 	"github.com/hdssbks/kubebuilder-demo/utils"
 	appv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
 )
 
+// FieldManager is the field manager used for server-side apply of the child resources.
+const FieldManager = "app-controller"
+
 // AppReconciler reconciles a App object
 type AppReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	// 添加事件上报机制，可以通过kubectl describe app app-sample查看到事件
 	Recorder record.EventRecorder
+	// Paused stops the controller from changing the child resources of every
+	// App, as if all of them set spec.suspend.
+	Paused bool
 }
 
 //+kubebuilder:rbac:groups=ingress.zq.com,resources=apps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ingress.zq.com,resources=apps/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ingress.zq.com,resources=apps/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;delete
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// 被引用的Secret变化时需要滚动pod。Secret只以metadata的形式watch，不缓存其中的数据，
+// 但metadata API同样需要secrets的get、list、watch权限
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // 由于我们的controller需要操作deployments，services，ingresses，当controller部署至集群中时，需要相应的权限，故需要添加相应的rbac
@@ -63,162 +85,329 @@ type AppReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.2/pkg/reconcile
 func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	logger := log.FromContext(ctx)
 	// 从缓存中获取app对象，如果没找到，表示删除事件，直接返回
 	app := &ingressv1beta1.App{}
 	if err := r.Get(ctx, req.NamespacedName, app); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	deploy := utils.NewDeploy(app)
 
-	if err := controllerutil.SetControllerReference(app, deploy, r.Scheme); err != nil {
+	// App正在被删除时，按照deletionPolicy清理子资源后移除finalizer
+	if !app.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, app)
+	}
+	// 只patch finalizers，避免与并发的spec修改冲突。merge patch会替换整个finalizers列表，
+	// 带上resourceVersion，其他controller同时修改了finalizers时patch失败并重新Reconcile
+	patch := client.MergeFromWithOptions(app.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if controllerutil.AddFinalizer(app, AppFinalizer) {
+		if err := r.Patch(ctx, app, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 暂停时不修改任何子资源，只刷新status；恢复后完整地重新同步所有子资源
+	if app.Spec.Suspend || r.Paused {
+		if !meta.IsStatusConditionTrue(app.Status.Conditions, ingressv1beta1.ConditionSuspended) {
+			r.Recorder.Event(app, corev1.EventTypeNormal, "Suspended", "Stopped changing the child resources")
+		}
+		return ctrl.Result{}, r.updateStatus(ctx, app, &reconcileReport{suspended: true}, nil)
+	}
+	if meta.IsStatusConditionTrue(app.Status.Conditions, ingressv1beta1.ConditionSuspended) {
+		r.Recorder.Event(app, corev1.EventTypeNormal, "Resumed", "Resyncing all child resources")
+	}
+
+	// 按照rollback-to注解从历史版本恢复spec，更新App后会再次触发Reconcile
+	if updated, err := r.rollbackToRevision(ctx, app); err != nil || updated {
 		return ctrl.Result{}, err
 	}
 
-	d := &appv1.Deployment{}
-	err := r.Get(ctx, req.NamespacedName, d)
-	if err != nil && !errors.IsNotFound(err) {
+	report := &reconcileReport{}
+	result, err := r.reconcileResources(ctx, req, app, report)
+	// 无论子资源是否处理成功，都将观察到的状态写回App的status
+	if statusErr := r.updateStatus(ctx, app, report, err); statusErr != nil && err == nil {
+		return ctrl.Result{}, statusErr
+	}
+	return result, err
+}
+
+// reconcileResources creates, updates or deletes the Deployment, Service and
+// Ingress of the App so that they match its spec.
+func (r *AppReconciler) reconcileResources(ctx context.Context, req ctrl.Request, app *ingressv1beta1.App, report *reconcileReport) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	// 记录当前generation的spec，用于查看历史和回滚
+	if err := r.recordRevision(ctx, app); err != nil {
+		logger.Error(err, "record revision failed")
 		return ctrl.Result{}, err
 	}
-	if err != nil && errors.IsNotFound(err) {
-		// Create Deployment
-		if err := r.Create(ctx, deploy); err != nil {
-			logger.Error(err, "create deployment failed")
-			// 写入事件
-			r.Recorder.Event(app, corev1.EventTypeWarning, "CreateDeploymentFailed", err.Error())
+	// 新版本发布失败时，所有子资源按照最近一次成功发布的版本渲染
+	app, err := r.reconcileRollback(ctx, app, report)
+	if err != nil {
+		logger.Error(err, "roll back failed")
+		return ctrl.Result{}, err
+	}
+	enableSvc := ptr.Deref(app.Spec.EnableSvc, false)
+	enableIngress := ptr.Deref(app.Spec.EnableIngress, false)
+
+	// 内联配置写入App所拥有的ConfigMap，未配置时删除
+	if len(app.Spec.Config) > 0 {
+		cm, err := utils.NewConfigMap(app)
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "configmap", err)
+		}
+		if _, err := r.applyResource(ctx, app, cm, report); err != nil {
+			logger.Error(err, "apply configmap failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyConfigMapFailed", err.Error())
 			return ctrl.Result{}, err
 		}
-		r.Recorder.Event(app, corev1.EventTypeNormal, "CreateDeploymentSuccess", "Create deployment success")
+	} else if err := r.deleteResource(ctx, app, types.NamespacedName{Namespace: app.Namespace, Name: app.ConfigMapName()}, &corev1.ConfigMap{}); err != nil {
+		logger.Error(err, "delete configmap failed")
+		return ctrl.Result{}, err
+	}
+
+	// 引用的配置发生变化时，checksum随之变化，从而触发pod滚动更新
+	checksum, err := r.configChecksum(ctx, app)
+	if err != nil {
+		logger.Error(err, "compute config checksum failed")
+		return ctrl.Result{}, err
 	}
-	if err == nil {
-		// Update Deploy
-		//Bug: 这里会反复触发更新
-		//原因：在187行SetupWithManager方法中，监听了Deployment，所以只要更新Deployment就会触发
-		//     此处更新和controllerManager更新Deployment都会触发更新事件，导致循环触发
-		//     这里只有Deployment的更新会触发App的Reconcile，Service和Ingress都不会触发，猜测的原因是Deployment Status的更新导致了该问题
-		//修复方法：
-		//方式1. 注释掉在148行SetupWithManager方法中对Deployment，Ingress，Service等的监听，该处的处理只是为了
-		//      手动删除Deployment等后能够自动重建，但正常不会出现这种情况，是否需要根据情况而定
-		//方式2. 加上判断条件，仅在app.Spec.Replicas != deployment.Spec.Replicas ||
-		//      app.Spec.Image != deployment.Spec.Template.Spec.Containers[0].Image时才更新deployment
-		//方式3. 添加Predicate，App的Spec发生变化时，才加入workqueue，例如:
-		/* 这里的predicate.GenerationChangedPredicate{}表示update事件中如果对象app.metadata.generation没有变化，则不加入到workqueue中
-		   而app.metadata.generation是一个int类型，当app.Spec发生变化，app.metadata.generation才会改变
-		func (r *AppReconciler) SetupWithManager(mgr ctrl.Manager) error {
-			return ctrl.NewControllerManagedBy(mgr).
-				For(&ingressv1beta1.App{}).
-				Owns(&appv1.Deployment{}).
-				Owns(&corev1.Service{}).
-				Owns(&netv1.Ingress{}).
-				WithEventFilter(predicate.GenerationChangedPredicate{}).
-				Complete(r)
-		}
-		*/
-		//		if *app.Spec.Replicas != *d.Spec.Replicas || app.Spec.Image != d.Spec.Template.Spec.Containers[0].Image {
-		if err := r.Update(ctx, deploy); err != nil {
-			logger.Error(err, "update deployment failed")
+	opts := utils.DeployOptions{ConfigChecksum: checksum, Revision: app.Generation}
+	var result ctrl.Result
+	var svcOpts utils.ServiceOptions
+	if app.BlueGreenEnabled() {
+		// 蓝绿发布时pod由两个颜色的Deployment承载，Service只选中生效的颜色
+		if result, err = r.reconcileBlueGreen(ctx, app, opts, &svcOpts, report); err != nil {
 			return ctrl.Result{}, err
 		}
-		//		}
+	} else if result, err = r.reconcileDeployment(ctx, app, opts, report); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	svc := utils.NewService(app)
-	if err := controllerutil.SetControllerReference(app, svc, r.Scheme); err != nil {
+	if app.Spec.Autoscaling != nil {
+		hpa, err := utils.NewHPA(app)
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "horizontalpodautoscaler", err)
+		}
+		if _, err := r.applyResource(ctx, app, hpa, report); err != nil {
+			logger.Error(err, "apply horizontalpodautoscaler failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyHPAFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteResource(ctx, app, req.NamespacedName, &autoscalingv2.HorizontalPodAutoscaler{}); err != nil {
+		logger.Error(err, "delete horizontalpodautoscaler failed")
 		return ctrl.Result{}, err
 	}
 
-	s := &corev1.Service{}
-	// 从缓存中查找service对象
-	err = r.Get(ctx, req.NamespacedName, s)
-	// 遇到错误，返回
-	if err != nil && !errors.IsNotFound(err) {
+	// 设置了minAvailable或maxUnavailable时，创建PodDisruptionBudget保护App的pod不被同时驱逐
+	if app.DisruptionBudgetEnabled() {
+		var pdbOpts utils.PDBOptions
+		if minAvailable, clamped := clampMinAvailable(app); clamped {
+			pdbOpts.MinAvailable = minAvailable
+			r.Recorder.Eventf(app, corev1.EventTypeWarning, "DisruptionBudgetClamped",
+				"minAvailable %s would block all evictions of the %d replicas, using %s", app.Spec.MinAvailable, app.MinReplicas(), minAvailable)
+		}
+		pdb, err := utils.NewPDB(app, pdbOpts)
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "poddisruptionbudget", err)
+		}
+		if _, err := r.applyResource(ctx, app, pdb, report); err != nil {
+			logger.Error(err, "apply poddisruptionbudget failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyPDBFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteResource(ctx, app, req.NamespacedName, &policyv1.PodDisruptionBudget{}); err != nil {
+		logger.Error(err, "delete poddisruptionbudget failed")
 		return ctrl.Result{}, err
 	}
-	// 没找到service，并且enableSvc=true,创建service
-	if err != nil && errors.IsNotFound(err) && *app.Spec.EnableSvc {
-		// Create Service
-		logger.Info("create service")
-		if err := r.Create(ctx, svc); err != nil {
-			logger.Error(err, "create service failed")
+
+	// 开启service时，创建或更新service，否则删除service
+	if enableSvc {
+		svc, err := utils.NewService(app, svcOpts)
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "service", err)
+		}
+		if _, err := r.applyResource(ctx, app, svc, report); err != nil {
+			logger.Error(err, "apply service failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyServiceFailed", err.Error())
 			return ctrl.Result{}, err
 		}
+	} else if err := r.deleteResource(ctx, app, req.NamespacedName, &corev1.Service{}); err != nil {
+		logger.Error(err, "delete service failed")
+		return ctrl.Result{}, err
 	}
-	// 找到service
-	if err == nil {
-		// 如果service enable,更新service
-		if *app.Spec.EnableSvc {
-			// Update Service
-			if err := r.Update(ctx, svc); err != nil {
-				logger.Error(err, "update service failed")
-				return ctrl.Result{}, err
-			}
-			// 否则删除service
-		} else {
-			if err := r.Delete(ctx, s); err != nil {
-				logger.Error(err, "delete service failed")
-				return ctrl.Result{}, err
-			}
+
+	// 蓝绿发布时，预览Service选中未生效的颜色，用于在切换前验证新版本
+	previewKey := types.NamespacedName{Namespace: app.Namespace, Name: app.PreviewServiceName()}
+	if enableSvc && app.BlueGreenEnabled() {
+		preview, err := utils.NewService(app, utils.ServiceOptions{
+			Name:     app.PreviewServiceName(),
+			Selector: colorSelector(app, otherColor(report.rollout.ActiveColor)),
+		})
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "preview service", err)
+		}
+		if _, err := r.applyResource(ctx, app, preview, report); err != nil {
+			logger.Error(err, "apply preview service failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyServiceFailed", err.Error())
+			return ctrl.Result{}, err
 		}
+	} else if err := r.deleteResource(ctx, app, previewKey, &corev1.Service{}); err != nil {
+		logger.Error(err, "delete preview service failed")
+		return ctrl.Result{}, err
 	}
 
-	// 当enableSvc为false时，直接返回，并检查是否存在ingress，如果存在，则删除
-	if !*app.Spec.EnableSvc {
-		//logger.Info("must enable service before create ingress")
-
-		i := &netv1.Ingress{}
-		err = r.Get(ctx, req.NamespacedName, i)
-		if err != nil && !errors.IsNotFound(err) {
+	// ingress依赖于service，service未开启时同样删除ingress
+	if enableSvc && enableIngress {
+		ing, err := utils.NewIngress(app)
+		if err != nil {
+			return ctrl.Result{}, r.renderFailed(ctx, app, "ingress", err)
+		}
+		if _, err := r.applyResource(ctx, app, ing, report); err != nil {
+			logger.Error(err, "apply ingress failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyIngressFailed", err.Error())
 			return ctrl.Result{}, err
-		} else if err != nil && errors.IsNotFound(err) {
-			return ctrl.Result{}, nil
-		} else if err == nil {
-			if err := r.Delete(ctx, i); err != nil {
-				logger.Error(err, "delete ingress failed")
-			}
 		}
-		return ctrl.Result{}, nil
+	} else if err := r.deleteResource(ctx, app, req.NamespacedName, &netv1.Ingress{}); err != nil {
+		logger.Error(err, "delete ingress failed")
+		return ctrl.Result{}, err
 	}
 
-	ing := utils.NewIngress(app)
+	return result, nil
+}
+
+// reconcileDeployment applies the Deployment of the App, together with the
+// canary Deployment during canary rollouts. The Deployments of a previous
+// blue/green rollout are deleted once the Deployment is ready.
+func (r *AppReconciler) reconcileDeployment(ctx context.Context, app *ingressv1beta1.App, opts utils.DeployOptions, report *reconcileReport) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
 
-	if err := controllerutil.SetControllerReference(app, ing, r.Scheme); err != nil {
+	// 金丝雀发布时，稳定版本的Deployment保持原镜像，新镜像由金丝雀Deployment承载
+	result, err := r.reconcileCanary(ctx, app, &opts, report)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	// 稳定版本的selector不选中金丝雀的pod，HPA和Deployment的状态只统计稳定版本
+	opts.ExcludeLabels = map[string]string{canaryTrackLabel: "canary"}
+	deploy, err := utils.NewDeploy(app, opts)
+	if err != nil {
+		return ctrl.Result{}, r.renderFailed(ctx, app, "deployment", err)
+	}
+	if replacing, err := r.replaceOnSelectorChange(ctx, app, deploy); err != nil || replacing {
+		if err != nil {
+			logger.Error(err, "replace deployment failed")
+		}
+		return ctrl.Result{Requeue: replacing}, err
+	}
+	// 开启自动扩缩容后，replicas交由HPA管理，不再由controller写入
+	if app.Spec.Autoscaling != nil {
+		if err := r.handOverReplicas(ctx, client.ObjectKeyFromObject(app)); err != nil {
+			logger.Error(err, "hand over deployment replicas failed")
+			return ctrl.Result{}, err
+		}
+	}
+	created, err := r.applyResource(ctx, app, deploy, report)
+	if err != nil {
+		logger.Error(err, "apply deployment failed")
+		// 写入事件
+		r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyDeploymentFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	if created {
+		r.Recorder.Event(app, corev1.EventTypeNormal, "CreateDeploymentSuccess", "Create deployment success")
+	}
 
-	i := &netv1.Ingress{}
-	// 从缓存中查找ingress对象
-	err = r.Get(ctx, req.NamespacedName, i)
-	// 遇到错误，返回
-	if err != nil && !errors.IsNotFound(err) {
+	// 从蓝绿发布切换而来时，两个颜色的Deployment在原Deployment可用之前继续提供服务，Service选中App的所有pod
+	live, err := r.getDeployment(ctx, client.ObjectKeyFromObject(app))
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	// 没找到ingress，创建
-	if err != nil && errors.IsNotFound(err) && *app.Spec.EnableIngress {
-		// Create Ingress
-		if err := r.Create(ctx, ing); err != nil {
-			logger.Error(err, "create ingress failed")
+	if live == nil || !deploymentReady(live, ptr.Deref(live.Spec.Replicas, 1)) {
+		return result, nil
+	}
+	for _, color := range []string{ingressv1beta1.ColorBlue, ingressv1beta1.ColorGreen} {
+		key := types.NamespacedName{Namespace: app.Namespace, Name: app.ColorDeploymentName(color)}
+		if err := r.deleteResource(ctx, app, key, &appv1.Deployment{}); err != nil {
+			logger.Error(err, "delete "+color+" deployment failed")
 			return ctrl.Result{}, err
 		}
 	}
-	// 找到ingress
-	if err == nil {
-		// 如果ingress enable,更新ingress
-		if *app.Spec.EnableIngress {
-			// Update Ingress
-			if err := r.Update(ctx, ing); err != nil {
-				logger.Error(err, "update ingress failed")
-				return ctrl.Result{}, err
-			}
-			// 否则删除ingress
-		} else {
-			if err := r.Delete(ctx, i); err != nil {
-				logger.Error(err, "delete ingress failed")
-				return ctrl.Result{}, err
-			}
+	return result, nil
+}
+
+// renderFailed records a Warning event for a template that could not be
+// rendered and returns the error to be reported in the App status.
+func (r *AppReconciler) renderFailed(ctx context.Context, app *ingressv1beta1.App, resource string, err error) error {
+	log.FromContext(ctx).Error(err, "render "+resource+" failed")
+	r.Recorder.Event(app, corev1.EventTypeWarning, ReasonRenderFailed, err.Error())
+	return withReason(ReasonRenderFailed, err)
+}
+
+// clampMinAvailable 通过scale子资源修改的replicas不经过webhook校验，渲染PDB时保证minAvailable
+// 小于App可能运行的副本数，否则PDB会阻止所有pod被驱逐，节点将无法排空
+func clampMinAvailable(app *ingressv1beta1.App) (*intstr.IntOrString, bool) {
+	v := app.Spec.MinAvailable
+	if v == nil {
+		return nil, false
+	}
+	replicas := int(app.MinReplicas())
+	// 与disruption controller保持一致，百分比向上取整
+	minAvailable, err := intstr.GetScaledValueFromIntOrPercent(v, replicas, true)
+	if err != nil || minAvailable < replicas {
+		return nil, false
+	}
+	return ptr.To(intstr.FromInt32(int32(max(replicas-1, 0)))), true
+}
+
+// applyResource creates or updates obj through server-side apply, after making
+// the App its controller. Only the fields rendered from the templates are owned
+// by the controller, fields set by other writers are left untouched.
+// Drift of an existing object is recorded in report, and is left in place when
+// the drift policy of the App is ReportOnly.
+// It reports whether the object did not exist before.
+func (r *AppReconciler) applyResource(ctx context.Context, app *ingressv1beta1.App, obj client.Object, report *reconcileReport) (bool, error) {
+	if err := controllerutil.SetControllerReference(app, obj, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := setRenderedHash(obj); err != nil {
+		return false, err
+	}
+
+	live := obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), live)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	created := errors.IsNotFound(err)
+	// 已存在但不受App控制的对象，按照adoptionPolicy决定是否接管
+	if !created && !metav1.IsControlledBy(live, app) {
+		if err := r.adopt(ctx, app, live); err != nil {
+			return false, err
+		}
+	} else if !created {
+		apply, err := r.detectDrift(ctx, app, obj, live, report)
+		if err != nil || !apply {
+			return false, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	// apply请求中不能携带resourceVersion和managedFields
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	if err := r.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// deleteResource deletes the object with the given key if it exists and is
+// controlled by the App. Objects owned by others are left untouched.
+func (r *AppReconciler) deleteResource(ctx context.Context, app *ingressv1beta1.App, key client.ObjectKey, obj client.Object) error {
+	if err := r.Get(ctx, key, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, app) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -234,12 +423,36 @@ func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
    orphan：只删除主资源，不删除子资源
    另外OwnerReference不能跨Namespace，即一个资源对象的OwnerReference只能在该资源对象的Namespace下
 */
+/*
+   App的status需要反映Deployment的副本状态，而Deployment的status更新不会改变metadata.generation，
+   所以Deployment上除了GenerationChangedPredicate外，还需要在status变化时触发Reconcile
+   App自身的status更新同样不改变generation，不会导致循环触发
+*/
+/*
+   为了及时发现子资源被手动修改（漂移），子资源的labels、annotations变化同样触发Reconcile；
+   Service的spec变化不会改变generation，所以Service不设置predicate
+   App的annotations变化同样触发Reconcile，用于蓝绿发布的手动切换
+*/
 func (r *AppReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 建立App到其引用的ConfigMap、Secret的索引，配置变化时据此找到需要Reconcile的App
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &ingressv1beta1.App{}, configRefIndex,
+		func(obj client.Object) []string {
+			return configRefs(obj.(*ingressv1beta1.App))
+		}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&ingressv1beta1.App{}).
-		Owns(&appv1.Deployment{}).
+		For(&ingressv1beta1.App{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&appv1.Deployment{}, builder.WithPredicates(
+			predicate.Or(childChangedPredicate(), deploymentStatusChangedPredicate()))).
 		Owns(&corev1.Service{}).
-		Owns(&netv1.Ingress{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Owns(&netv1.Ingress{}, builder.WithPredicates(childChangedPredicate())).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}, builder.WithPredicates(childChangedPredicate())).
+		Owns(&policyv1.PodDisruptionBudget{}, builder.WithPredicates(childChangedPredicate())).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.appsForConfig("configmap"))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.appsForConfig("secret")), builder.OnlyMetadata).
 		Complete(r)
 }
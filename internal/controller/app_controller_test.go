@@ -18,11 +18,21 @@ package controller
 
 import (
 	"context"
+	"fmt"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -51,7 +61,12 @@ var _ = Describe("App Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: ingressv1beta1.AppSpec{
+						EnableSvc:     ptr.To(true),
+						EnableIngress: ptr.To(false),
+						Replicas:      ptr.To[int32](1),
+						Image:         "nginx:1.25",
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -61,24 +76,636 @@ var _ = Describe("App Controller", func() {
 			// TODO(user): Cleanup logic after each test, like removing the resource instance.
 			resource := &ingressv1beta1.App{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if errors.IsNotFound(err) {
+				return
+			}
 			Expect(err).NotTo(HaveOccurred())
 
 			By("Cleanup the specific resource instance App")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			// finalizer由controller在清理子资源后移除
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			// envtest中没有垃圾回收，需要手动删除App的历史版本
+			Expect(k8sClient.DeleteAllOf(ctx, &appv1.ControllerRevision{}, client.InNamespace("default"),
+				client.MatchingLabels{revisionLabel: resourceName})).To(Succeed())
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &AppReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			By("Checking the status of the App")
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+			Expect(resource.Status.Replicas).To(Equal(int32(1)))
+			Expect(resource.Status.Selector).To(Equal("app=" + resourceName))
+			// envtest中没有运行Deployment控制器，Deployment不会变为可用
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, ingressv1beta1.ConditionDeploymentAvailable)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, ingressv1beta1.ConditionServiceReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, ingressv1beta1.ConditionIngressReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, ingressv1beta1.ConditionReconcileError)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, ingressv1beta1.ConditionReady)).To(BeTrue())
+		})
+
+		It("should materialise inline config into an owned ConfigMap", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			By("Setting inline config on the App")
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Config = map[string]string{"LOG_LEVEL": "debug"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			cm := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: resourceName + "-config"}, cm)).To(Succeed())
+			Expect(cm.Data).To(HaveKeyWithValue("LOG_LEVEL", "debug"))
+			Expect(metav1.IsControlledBy(cm, resource)).To(BeTrue())
+
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			checksum := deploy.Spec.Template.Annotations["ingress.zq.com/config-checksum"]
+			Expect(checksum).NotTo(BeEmpty())
+
+			By("Changing the config rolls the pod template")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Config["LOG_LEVEL"] = "info"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Annotations["ingress.zq.com/config-checksum"]).NotTo(Equal(checksum))
+		})
+
+		It("should roll the pod template only when the checksum of a referenced Secret changes", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			checksumOf := func() string {
+				deploy := &appv1.Deployment{}
+				Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+				return deploy.Spec.Template.Annotations["ingress.zq.com/config-checksum"]
+			}
+			reconcileApp := func() {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			By("Referencing a Secret with a checksum annotation")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        resourceName + "-secret",
+					Namespace:   "default",
+					Annotations: map[string]string{ingressv1beta1.ChecksumAnnotation: "v1"},
+				},
+				StringData: map[string]string{"TOKEN": "a"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, secret))).To(Succeed())
+			})
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.EnvFrom = []corev1.EnvFromSource{{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name}},
+			}}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileApp()
+			checksum := checksumOf()
+			Expect(checksum).NotTo(BeEmpty())
+
+			By("Changing only the labels of the Secret keeps the pod template")
+			secret.Labels = map[string]string{"team": "a"}
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+			reconcileApp()
+			Expect(checksumOf()).To(Equal(checksum))
+
+			By("Changing the checksum annotation rolls the pod template")
+			secret.Annotations[ingressv1beta1.ChecksumAnnotation] = "v2"
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+			reconcileApp()
+			Expect(checksumOf()).NotTo(Equal(checksum))
+		})
+
+		It("should adopt existing children according to the adoption policy", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			By("Creating a Service controlled by another object")
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "v1",
+						Kind:       "ConfigMap",
+						Name:       "legacy",
+						UID:        "legacy-uid",
+						Controller: ptr.To(true),
+					}},
+				},
+				Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+			}
+			Expect(k8sClient.Create(ctx, svc)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			adopted := meta.FindStatusCondition(resource.Status.Conditions, ingressv1beta1.ConditionAdopted)
+			Expect(adopted).NotTo(BeNil())
+			Expect(adopted.Status).To(Equal(metav1.ConditionFalse))
+			Expect(adopted.Reason).To(Equal(ReasonControlledByOther))
+
+			By("Forcing the adoption")
+			resource.Spec.AdoptionPolicy = ingressv1beta1.AdoptionPolicyForce
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, svc)).To(Succeed())
+			Expect(metav1.IsControlledBy(svc, resource)).To(BeTrue())
+			Expect(svc.OwnerReferences).To(HaveLen(1))
+		})
+
+		It("should report drift of the Deployment with the ReportOnly policy", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DriftPolicy = ingressv1beta1.DriftPolicyReportOnly
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Editing the Deployment by hand")
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			deploy.Spec.Template.Spec.Containers[0].Image = "nginx:1.26"
+			Expect(k8sClient.Update(ctx, deploy)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.26"))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.DriftedFields).To(ConsistOf(
+				"Deployment/" + resourceName + ":spec.template.spec.containers[0].image"))
+
+			By("Correcting the drift")
+			resource.Spec.DriftPolicy = ingressv1beta1.DriftPolicyCorrect
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.DriftedFields).To(BeEmpty())
+		})
+
+		It("should roll out a new image through a canary Deployment", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			canaryName := types.NamespacedName{Namespace: "default", Name: resourceName + "-canary"}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Rollout = &ingressv1beta1.AppRollout{
+				Strategy: ingressv1beta1.RolloutStrategyCanary,
+				Canary:   &ingressv1beta1.AppCanary{Steps: []ingressv1beta1.AppCanaryStep{{Weight: 50}}},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Changing the image")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Image = "nginx:1.26"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			canary := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, canaryName, canary)).To(Succeed())
+			Expect(canary.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.26"))
+			Expect(canary.Spec.Template.Labels).To(HaveKeyWithValue("track", "canary"))
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			Expect(deploy.Spec.Replicas).To(Equal(ptr.To[int32](0)))
+			Expect(deploy.Spec.Selector.MatchExpressions).To(ConsistOf(metav1.LabelSelectorRequirement{
+				Key:      "track",
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{"canary"},
+			}))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Rollout).NotTo(BeNil())
+			Expect(resource.Status.Rollout.Phase).To(Equal(ingressv1beta1.RolloutProgressing))
+			Expect(resource.Status.Rollout.Step).To(Equal(ptr.To[int32](0)))
+
+			By("Marking the canary ready")
+			canary.Status = appv1.DeploymentStatus{
+				ObservedGeneration: canary.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      1,
+				AvailableReplicas:  1,
+				Conditions: []appv1.DeploymentCondition{{
+					Type:   appv1.DeploymentAvailable,
+					Status: corev1.ConditionTrue,
+				}},
+			}
+			Expect(k8sClient.Status().Update(ctx, canary)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.26"))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, canaryName, canary))).To(BeTrue())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Rollout.Phase).To(Equal(ingressv1beta1.RolloutPromoted))
+			Expect(resource.Status.Rollout.StableImage).To(Equal("nginx:1.26"))
+		})
+
+		It("should switch the Service between blue and green Deployments", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			blueName := types.NamespacedName{Namespace: "default", Name: resourceName + "-blue"}
+			greenName := types.NamespacedName{Namespace: "default", Name: resourceName + "-green"}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Rollout = &ingressv1beta1.AppRollout{
+				Strategy:  ingressv1beta1.RolloutStrategyBlueGreen,
+				BlueGreen: &ingressv1beta1.AppBlueGreen{ManualPromotion: true},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			blue := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, blueName, blue)).To(Succeed())
+			Expect(blue.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue("color", "blue"))
+
+			By("Changing the image")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Image = "nginx:1.26"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			green := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, greenName, green)).To(Succeed())
+			Expect(green.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.26"))
+			preview := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: resourceName + "-preview"}, preview)).To(Succeed())
+			Expect(preview.Spec.Selector).To(HaveKeyWithValue("color", "green"))
+
+			By("Marking green ready")
+			green.Status = appv1.DeploymentStatus{
+				ObservedGeneration: green.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      1,
+				AvailableReplicas:  1,
+				Conditions: []appv1.DeploymentCondition{{
+					Type:   appv1.DeploymentAvailable,
+					Status: corev1.ConditionTrue,
+				}},
+			}
+			Expect(k8sClient.Status().Update(ctx, green)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Rollout.Phase).To(Equal(ingressv1beta1.RolloutPaused))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue("color", "blue"))
+
+			By("Promoting green")
+			resource.Annotations = map[string]string{ingressv1beta1.PromoteAnnotation: "true"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue("color", "green"))
+			Expect(k8sClient.Get(ctx, blueName, blue)).To(Succeed())
+			Expect(blue.Spec.Replicas).To(Equal(ptr.To[int32](0)))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Annotations).NotTo(HaveKey(ingressv1beta1.PromoteAnnotation))
+			Expect(resource.Status.Rollout.Phase).To(Equal(ingressv1beta1.RolloutPromoted))
+			Expect(resource.Status.Rollout.ActiveColor).To(Equal("green"))
+
+			By("Switching back to a rolling update")
+			resource.Spec.Rollout = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(k8sClient.Get(ctx, greenName, green)).To(Succeed())
+
+			deploy.Status = appv1.DeploymentStatus{
+				ObservedGeneration: deploy.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      1,
+				AvailableReplicas:  1,
+				Conditions: []appv1.DeploymentCondition{{
+					Type:   appv1.DeploymentAvailable,
+					Status: corev1.ConditionTrue,
+				}},
+			}
+			Expect(k8sClient.Status().Update(ctx, deploy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, greenName, green))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, blueName, blue))).To(BeTrue())
+		})
+
+		It("should roll back a failed rollout to the last good revision", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Rollout = &ingressv1beta1.AppRollout{AutoRollback: true}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Marking the Deployment ready")
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			deploy.Status = appv1.DeploymentStatus{
+				ObservedGeneration: deploy.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      1,
+				AvailableReplicas:  1,
+				Conditions: []appv1.DeploymentCondition{{
+					Type:   appv1.DeploymentAvailable,
+					Status: corev1.ConditionTrue,
+				}},
+			}
+			Expect(k8sClient.Status().Update(ctx, deploy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.LastGoodImage).To(Equal("nginx:1.25"))
+			Expect(resource.Status.LastGoodRevision).To(Equal(resource.Generation))
+			lastGood := resource.Generation
+
+			By("Rolling out a spec that never becomes ready")
+			resource.Spec.Image = "nginx:broken"
+			resource.Spec.Env = []corev1.EnvVar{{Name: "MODE", Value: "broken"}}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:broken"))
+
+			deploy.Status.ObservedGeneration = deploy.Generation
+			deploy.Status.Replicas = 2
+			deploy.Status.Conditions = append(deploy.Status.Conditions, appv1.DeploymentCondition{
+				Type:   appv1.DeploymentProgressing,
+				Status: corev1.ConditionFalse,
+				Reason: "ProgressDeadlineExceeded",
+			})
+			Expect(k8sClient.Status().Update(ctx, deploy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			Expect(deploy.Spec.Template.Spec.Containers[0].Env).To(BeEmpty())
+			Expect(deploy.Annotations).To(HaveKeyWithValue(revisionAnnotation, fmt.Sprint(lastGood)))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.RolledBackRevision).To(Equal(resource.Generation))
+			Expect(resource.Status.RolledBackImage).To(Equal("nginx:broken"))
+			Expect(resource.Status.LastGoodRevision).To(Equal(lastGood))
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, ingressv1beta1.ConditionRolledBack)).To(BeTrue())
+		})
+
+		It("should record revisions of the spec and roll back to them", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			first := resource.Generation
+			revision := &appv1.ControllerRevision{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: revisionName(resource, first)}, revision)).To(Succeed())
+			Expect(revision.Revision).To(Equal(first))
+
+			By("Changing the image")
+			resource.Spec.Image = "nginx:1.26"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			revisions := &appv1.ControllerRevisionList{}
+			Expect(k8sClient.List(ctx, revisions, client.InNamespace("default"), client.MatchingLabels{revisionLabel: resourceName})).To(Succeed())
+			Expect(revisions.Items).To(HaveLen(2))
+			for _, rev := range revisions.Items {
+				Expect(metav1.IsControlledBy(&rev, resource)).To(BeTrue())
+			}
+
+			By("Rolling back to the first revision")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Annotations = map[string]string{ingressv1beta1.RollbackToAnnotation: fmt.Sprint(first)}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Spec.Image).To(Equal("nginx:1.25"))
+			Expect(resource.Annotations).NotTo(HaveKey(ingressv1beta1.RollbackToAnnotation))
+		})
+
+		It("should record revisions of an App recreated with the same name", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			previous := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, previous)).To(Succeed())
+
+			By("Deleting the App while its revisions are left behind")
+			Expect(k8sClient.Delete(ctx, previous)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &ingressv1beta1.App{}))).To(BeTrue())
+
+			By("Recreating the App")
+			resource := &ingressv1beta1.App{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: ingressv1beta1.AppSpec{
+					EnableSvc:     ptr.To(true),
+					EnableIngress: ptr.To(false),
+					Replicas:      ptr.To[int32](1),
+					Image:         "nginx:1.26",
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			revision := &appv1.ControllerRevision{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: revisionName(resource, resource.Generation)}, revision)).To(Succeed())
+			Expect(metav1.IsControlledBy(revision, resource)).To(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: revisionName(previous, previous.Generation)}, revision)).To(Succeed())
+			Expect(metav1.IsControlledBy(revision, previous)).To(BeTrue())
+		})
+
+		It("should leave the children of a suspended App untouched", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Suspend = true
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			By("Hand-editing the Deployment")
+			deploy := &appv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			deploy.Spec.Template.Spec.Containers[0].Image = "nginx:hotfix"
+			Expect(k8sClient.Update(ctx, deploy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:hotfix"))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, ingressv1beta1.ConditionSuspended)).To(BeTrue())
+
+			By("Resuming the App")
+			resource.Spec.Suspend = false
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, ingressv1beta1.ConditionSuspended)).To(BeTrue())
+		})
+
+		It("should keep minAvailable below the replicas set through the scale subresource", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Replicas = ptr.To[int32](3)
+			resource.Spec.MinAvailable = ptr.To(intstr.FromInt32(2))
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			pdb := &policyv1.PodDisruptionBudget{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, pdb)).To(Succeed())
+			Expect(pdb.Spec.MinAvailable).To(Equal(ptr.To(intstr.FromInt32(2))))
+
+			By("Scaling the App down to two replicas")
+			scale := &autoscalingv1.Scale{}
+			Expect(k8sClient.SubResource("scale").Get(ctx, resource, scale)).To(Succeed())
+			scale.Spec.Replicas = 2
+			Expect(k8sClient.SubResource("scale").Update(ctx, resource, client.WithSubResourceBody(scale))).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, pdb)).To(Succeed())
+			Expect(pdb.Spec.MinAvailable).To(Equal(ptr.To(intstr.FromInt32(1))))
+		})
+
+		It("should keep the Service when deleting an App with the RetainService policy", func() {
+			controllerReconciler := &AppReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			resource := &ingressv1beta1.App{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DeletionPolicy = ingressv1beta1.DeletionPolicyRetainService
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(AppFinalizer))
+
+			By("Deleting the App")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))).To(BeTrue())
+
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &appv1.Deployment{}))).To(BeTrue())
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, svc)).To(Succeed())
+			Expect(svc.OwnerReferences).To(BeEmpty())
+			Expect(k8sClient.Delete(ctx, svc)).To(Succeed())
 		})
 	})
 })
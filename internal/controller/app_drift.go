@@ -0,0 +1,175 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// renderedHashAnnotation 记录子资源渲染结果的hash。渲染结果与上次apply时相同，
+// 而子资源的字段不同时，才视为被其他写入者修改（漂移）
+const renderedHashAnnotation = "ingress.zq.com/rendered-hash"
+
+// reconcileReport collects what a reconcile observed besides its error, to be
+// written to the App status.
+type reconcileReport struct {
+	// drifted are the fields of the child resources found to differ from the
+	// rendered state.
+	drifted []string
+	// rollout is the state of the rollout of the App's image.
+	rollout *ingressv1beta1.AppRolloutStatus
+	// rolledBackRevision is the revision of the spec whose failed rollout was
+	// reverted.
+	rolledBackRevision int64
+	// suspended is set when the child resources were left untouched because
+	// the App or the controller is paused.
+	suspended bool
+}
+
+// setRenderedHash annotates the rendered object with the hash of its content.
+func setRenderedHash(obj client.Object) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[renderedHashAnnotation] = hex.EncodeToString(sum[:])
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// detectDrift compares the live child resource to the rendered one and reports
+// the drifted fields. Only drift caused by other writers is reported: when the
+// rendered object changed since it was last applied, differences are expected.
+// It reports whether the rendered object should be applied.
+func (r *AppReconciler) detectDrift(ctx context.Context, app *ingressv1beta1.App, desired, live client.Object, report *reconcileReport) (bool, error) {
+	if desired.GetAnnotations()[renderedHashAnnotation] != live.GetAnnotations()[renderedHashAnnotation] {
+		return true, nil
+	}
+	desiredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return false, err
+	}
+	liveMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false, err
+	}
+	fields := driftedFields(desiredMap, liveMap)
+	if len(fields) == 0 {
+		return true, nil
+	}
+
+	kind := desired.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(desired, r.Scheme); err == nil {
+		kind = gvk.Kind
+	}
+	for _, f := range fields {
+		report.drifted = append(report.drifted, fmt.Sprintf("%s/%s:%s", kind, desired.GetName(), f))
+	}
+	message := fmt.Sprintf("%s %s drifted: %s", kind, desired.GetName(), strings.Join(fields, ", "))
+	if app.Spec.DriftPolicy == ingressv1beta1.DriftPolicyReportOnly {
+		r.Recorder.Event(app, corev1.EventTypeWarning, "DriftDetected", message)
+		return false, nil
+	}
+	r.Recorder.Event(app, corev1.EventTypeNormal, "DriftCorrected", message)
+	return true, nil
+}
+
+// driftedFields returns the paths of the fields rendered in desired whose value
+// differs in live. Fields only present in live, e.g. defaulted by the API
+// server, are not compared. Of the metadata only labels and annotations count.
+func driftedFields(desired, live map[string]interface{}) []string {
+	var fields []string
+	for _, key := range sortedKeys(desired) {
+		switch key {
+		case "apiVersion", "kind", "status":
+		case "metadata":
+			desiredMeta, _ := desired[key].(map[string]interface{})
+			liveMeta, _ := live[key].(map[string]interface{})
+			for _, metaKey := range []string{"labels", "annotations"} {
+				if v, ok := desiredMeta[metaKey]; ok {
+					fields = diffValue(v, liveMeta[metaKey], "metadata."+metaKey, fields)
+				}
+			}
+		default:
+			fields = diffValue(desired[key], live[key], key, fields)
+		}
+	}
+	return fields
+}
+
+// diffValue appends to fields the paths under path where desired and live differ.
+func diffValue(desired, live interface{}, path string, fields []string) []string {
+	switch d := desired.(type) {
+	case nil:
+		return fields
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return append(fields, path)
+		}
+		for _, key := range sortedKeys(d) {
+			fields = diffValue(d[key], l[key], path+"."+key, fields)
+		}
+		return fields
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return append(fields, path)
+		}
+		for i := range d {
+			fields = diffValue(d[i], l[i], fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+		return fields
+	case string:
+		// 模板中未设置的非omitempty字段为空字符串，其值由API server填充默认值
+		if d == "" {
+			return fields
+		}
+	}
+	if !reflect.DeepEqual(desired, live) {
+		return append(fields, path)
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
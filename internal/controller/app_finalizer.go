@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// AppFinalizer is added to every App so that its child resources are cleaned
+// up according to its deletion policy before the App is removed.
+const AppFinalizer = "ingress.zq.com/cleanup"
+
+// appChild is a child resource the App may own.
+type appChild struct {
+	kind string
+	key  client.ObjectKey
+	obj  client.Object
+}
+
+// appChildren returns the child resources of the App in the order they are
+// cleaned up: the Ingress first, so that no traffic is routed to pods being removed.
+func appChildren(app *ingressv1beta1.App) []appChild {
+	key := client.ObjectKeyFromObject(app)
+	return []appChild{
+		{kind: "Ingress", key: key, obj: &netv1.Ingress{}},
+		{kind: "Service", key: key, obj: &corev1.Service{}},
+		{kind: "Service", key: client.ObjectKey{Namespace: app.Namespace, Name: app.PreviewServiceName()}, obj: &corev1.Service{}},
+		{kind: "HorizontalPodAutoscaler", key: key, obj: &autoscalingv2.HorizontalPodAutoscaler{}},
+		{kind: "PodDisruptionBudget", key: key, obj: &policyv1.PodDisruptionBudget{}},
+		{kind: "Deployment", key: key, obj: &appv1.Deployment{}},
+		{kind: "Deployment", key: client.ObjectKey{Namespace: app.Namespace, Name: app.CanaryName()}, obj: &appv1.Deployment{}},
+		{kind: "Deployment", key: client.ObjectKey{Namespace: app.Namespace, Name: app.ColorDeploymentName(ingressv1beta1.ColorBlue)}, obj: &appv1.Deployment{}},
+		{kind: "Deployment", key: client.ObjectKey{Namespace: app.Namespace, Name: app.ColorDeploymentName(ingressv1beta1.ColorGreen)}, obj: &appv1.Deployment{}},
+		{kind: "ConfigMap", key: client.ObjectKey{Namespace: app.Namespace, Name: app.ConfigMapName()}, obj: &corev1.ConfigMap{}},
+	}
+}
+
+// finalize cleans up the child resources of a deleted App according to its
+// deletion policy, then removes the finalizer so that the App can go away.
+func (r *AppReconciler) finalize(ctx context.Context, app *ingressv1beta1.App) error {
+	if !controllerutil.ContainsFinalizer(app, AppFinalizer) {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+
+	policy := app.Spec.DeletionPolicy
+	if policy == "" {
+		policy = ingressv1beta1.DeletionPolicyDelete
+	}
+	for _, child := range appChildren(app) {
+		orphan := policy == ingressv1beta1.DeletionPolicyOrphan ||
+			(policy == ingressv1beta1.DeletionPolicyRetainService && child.kind == "Service" && child.key.Name == app.Name)
+		if err := r.cleanupChild(ctx, app, child, orphan); err != nil {
+			logger.Error(err, "clean up "+child.kind+" failed")
+			r.Recorder.Event(app, corev1.EventTypeWarning, "CleanupFailed",
+				fmt.Sprintf("Clean up %s %s failed: %v", child.kind, child.key.Name, err))
+			return err
+		}
+	}
+
+	// 带上resourceVersion，避免覆盖其他controller同时修改的finalizers
+	patch := client.MergeFromWithOptions(app.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.RemoveFinalizer(app, AppFinalizer)
+	return r.Patch(ctx, app, patch)
+}
+
+// cleanupChild deletes the child resource, or releases it from the App when
+// orphan is set. Objects that are missing or not controlled by the App are skipped.
+func (r *AppReconciler) cleanupChild(ctx context.Context, app *ingressv1beta1.App, child appChild, orphan bool) error {
+	if err := r.Get(ctx, child.key, child.obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(child.obj, app) {
+		return nil
+	}
+
+	if !orphan {
+		if err := client.IgnoreNotFound(r.Delete(ctx, child.obj)); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, "Deleted", "Deleted %s %s", child.kind, child.key.Name)
+		return nil
+	}
+
+	// 去掉指向App的ownerReference，App删除后垃圾回收不会再删除该资源
+	patch := client.MergeFrom(child.obj.DeepCopyObject().(client.Object))
+	var refs []metav1.OwnerReference
+	for _, ref := range child.obj.GetOwnerReferences() {
+		if ref.UID != app.UID {
+			refs = append(refs, ref)
+		}
+	}
+	child.obj.SetOwnerReferences(refs)
+	if err := client.IgnoreNotFound(r.Patch(ctx, child.obj, patch)); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, "Orphaned", "Orphaned %s %s", child.kind, child.key.Name)
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// revisionLabel 标记ControllerRevision所属的App，用于列出App的历史版本
+const revisionLabel = "ingress.zq.com/app"
+
+// defaultRevisionHistoryLimit is the number of revisions kept when
+// spec.revisionHistoryLimit is not set.
+const defaultRevisionHistoryLimit = 10
+
+// revisionName returns the name of the ControllerRevision recording the spec
+// of the given generation of the App. The name includes a hash of the App UID
+// because the generation starts over when an App is recreated, while the
+// revisions of the previous App may be orphaned or not yet garbage collected.
+func revisionName(app *ingressv1beta1.App, revision int64) string {
+	sum := sha256.Sum256([]byte(app.UID))
+	return fmt.Sprintf("%s-%s-%d", app.Name, hex.EncodeToString(sum[:])[:8], revision)
+}
+
+// recordRevision snapshots the spec of the current generation of the App into
+// a ControllerRevision and prunes the revisions beyond the history limit.
+func (r *AppReconciler) recordRevision(ctx context.Context, app *ingressv1beta1.App) error {
+	revisions, err := r.listRevisions(ctx, app)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(revisions, func(rev appv1.ControllerRevision) bool { return rev.Revision == app.Generation }) {
+		data, err := json.Marshal(app.Spec)
+		if err != nil {
+			return err
+		}
+		rev := &appv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      revisionName(app, app.Generation),
+				Namespace: app.Namespace,
+				Labels:    map[string]string{revisionLabel: app.Name},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: app.Generation,
+		}
+		if err := controllerutil.SetControllerReference(app, rev, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, rev); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				return err
+			}
+			// 缓存中可能还没有刚创建的版本；同名的对象不属于App或内容不同时不能当作App的版本
+			existing := &appv1.ControllerRevision{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(rev), existing); err != nil {
+				return err
+			}
+			if !metav1.IsControlledBy(existing, app) || existing.Revision != rev.Revision || !sameRevisionData(existing, data) {
+				return fmt.Errorf("controllerrevision %s already exists and is not revision %d of the app", rev.Name, rev.Revision)
+			}
+		}
+		revisions = append(revisions, *rev)
+	}
+
+	// 删除超出历史数量限制的最旧版本，自动回滚的目标版本除外
+	limit := int(ptr.Deref(app.Spec.RevisionHistoryLimit, defaultRevisionHistoryLimit))
+	for i := 0; i < len(revisions)-limit; i++ {
+		if revisions[i].Revision == app.Status.LastGoodRevision {
+			continue
+		}
+		if err := r.Delete(ctx, &revisions[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameRevisionData reports whether the revision records the same spec as data.
+func sameRevisionData(rev *appv1.ControllerRevision, data []byte) bool {
+	recorded, spec := ingressv1beta1.AppSpec{}, ingressv1beta1.AppSpec{}
+	if json.Unmarshal(rev.Data.Raw, &recorded) != nil || json.Unmarshal(data, &spec) != nil {
+		return false
+	}
+	return equality.Semantic.DeepEqual(recorded, spec)
+}
+
+// listRevisions returns the ControllerRevisions controlled by the App, oldest
+// first. Only these are pruned or used for rollbacks.
+func (r *AppReconciler) listRevisions(ctx context.Context, app *ingressv1beta1.App) ([]appv1.ControllerRevision, error) {
+	list := &appv1.ControllerRevisionList{}
+	if err := r.List(ctx, list, client.InNamespace(app.Namespace), client.MatchingLabels{revisionLabel: app.Name}); err != nil {
+		return nil, err
+	}
+	var revisions []appv1.ControllerRevision
+	for _, rev := range list.Items {
+		if metav1.IsControlledBy(&rev, app) {
+			revisions = append(revisions, rev)
+		}
+	}
+	slices.SortFunc(revisions, func(a, b appv1.ControllerRevision) int { return cmp.Compare(a.Revision, b.Revision) })
+	return revisions, nil
+}
+
+// rollbackToRevision restores the spec recorded in the revision named by the
+// RollbackToAnnotation and removes the annotation. It reports whether the App
+// was updated, in which case the update triggers the next reconcile.
+func (r *AppReconciler) rollbackToRevision(ctx context.Context, app *ingressv1beta1.App) (bool, error) {
+	value, ok := app.Annotations[ingressv1beta1.RollbackToAnnotation]
+	if !ok {
+		return false, nil
+	}
+	logger := log.FromContext(ctx)
+	delete(app.Annotations, ingressv1beta1.RollbackToAnnotation)
+
+	revisions, err := r.listRevisions(ctx, app)
+	if err != nil {
+		return false, err
+	}
+	idx := slices.IndexFunc(revisions, func(rev appv1.ControllerRevision) bool {
+		return strconv.FormatInt(rev.Revision, 10) == value
+	})
+	// 版本不存在时只移除注解，避免反复回滚失败
+	if idx < 0 {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RollbackFailed", "Revision %s of the app does not exist", value)
+		return true, r.Update(ctx, app)
+	}
+	spec := ingressv1beta1.AppSpec{}
+	if err := json.Unmarshal(revisions[idx].Data.Raw, &spec); err != nil {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RollbackFailed", "Decode revision %s failed: %v", value, err)
+		return true, r.Update(ctx, app)
+	}
+	app.Spec = spec
+	if err := r.Update(ctx, app); err != nil {
+		logger.Error(err, "rollback to revision failed", "revision", value)
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RollbackFailed", "Restore revision %s failed: %v", value, err)
+		return false, err
+	}
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, "RolledBackToRevision", "Restored the spec of revision %s", value)
+	return true, nil
+}
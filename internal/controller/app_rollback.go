@@ -0,0 +1,87 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// Reasons used for the RolledBack condition.
+const (
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonRolloutHealthy           = "RolloutHealthy"
+)
+
+// revisionAnnotation 记录Deployment渲染所依据的App版本，用于判断发布失败的是哪个版本
+const revisionAnnotation = "ingress.zq.com/revision"
+
+// reconcileRollback returns the App the child resources are rendered from.
+// When the rollout of the current revision of the spec exceeded the progress
+// deadline of the Deployment, it is the App with the spec of the last
+// known-good revision, and its generation set to that revision.
+func (r *AppReconciler) reconcileRollback(ctx context.Context, app *ingressv1beta1.App, report *reconcileReport) (*ingressv1beta1.App, error) {
+	if !app.AutoRollbackEnabled() {
+		return app, nil
+	}
+	lastGood := app.Status.LastGoodRevision
+	// 没有可回滚的版本，或者当前版本就是最近一次成功发布的版本
+	if lastGood == 0 || lastGood == app.Generation {
+		return app, nil
+	}
+	// 已经回滚的版本保持回滚，直到spec再次修改
+	if app.Status.RolledBackRevision != app.Generation {
+		deploy, err := r.getDeployment(ctx, client.ObjectKeyFromObject(app))
+		if err != nil {
+			return nil, err
+		}
+		if deploy == nil || deploy.Annotations[revisionAnnotation] != strconv.FormatInt(app.Generation, 10) || !progressDeadlineExceeded(deploy) {
+			return app, nil
+		}
+	}
+
+	revisions, err := r.listRevisions(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(revisions, func(rev appv1.ControllerRevision) bool { return rev.Revision == lastGood })
+	if idx < 0 {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RollbackFailed", "Revision %d of the app does not exist", lastGood)
+		return app, nil
+	}
+	spec := ingressv1beta1.AppSpec{}
+	if err := json.Unmarshal(revisions[idx].Data.Raw, &spec); err != nil {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RollbackFailed", "Decode revision %d failed: %v", lastGood, err)
+		return app, nil
+	}
+	good := app.DeepCopy()
+	good.Spec, good.Generation = spec, lastGood
+	if app.Status.RolledBackRevision != app.Generation {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "RolledBack",
+			"Rollout of revision %d exceeded its progress deadline, rolled back to revision %d", app.Generation, lastGood)
+	}
+	report.rolledBackRevision = app.Generation
+	return good, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+	"github.com/hdssbks/kubebuilder-demo/utils"
+)
+
+// canaryTrackLabel 区分金丝雀Deployment的pod，金丝雀pod同时带有app标签，从而被Service选中，
+// 稳定版本Deployment的selector排除带有该标签的pod
+const canaryTrackLabel = "track"
+
+// reconcileCanary drives the canary rollout of the App's image. It applies or
+// deletes the canary Deployment and adjusts opts so that the stable Deployment
+// keeps running the stable image until the canary is promoted.
+func (r *AppReconciler) reconcileCanary(ctx context.Context, app *ingressv1beta1.App, opts *utils.DeployOptions, report *reconcileReport) (ctrl.Result, error) {
+	canaryKey := types.NamespacedName{Namespace: app.Namespace, Name: app.CanaryName()}
+	if !app.CanaryEnabled() {
+		return ctrl.Result{}, r.deleteResource(ctx, app, canaryKey, &appv1.Deployment{})
+	}
+	logger := log.FromContext(ctx)
+
+	stable, err := r.getDeployment(ctx, client.ObjectKeyFromObject(app))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := &ingressv1beta1.AppRolloutStatus{}
+	if app.Status.Rollout != nil {
+		status = app.Status.Rollout.DeepCopy()
+	}
+	report.rollout = status
+	// 首次开启金丝雀发布时，以当前Deployment的镜像作为稳定版本，Deployment不存在时直接使用新镜像
+	if status.StableImage == "" {
+		status.StableImage = app.Spec.Image
+		if image := containerImage(stable); image != "" {
+			status.StableImage = image
+		}
+	}
+	opts.Image = status.StableImage
+
+	// 没有需要发布的新镜像，或者新镜像的发布已被中止
+	if app.Spec.Image == status.StableImage || (status.Phase == ingressv1beta1.RolloutAborted && status.CanaryImage == app.Spec.Image) {
+		if app.Spec.Image == status.StableImage && status.Phase != ingressv1beta1.RolloutPromoted {
+			*status = ingressv1beta1.AppRolloutStatus{StableImage: status.StableImage}
+		}
+		return ctrl.Result{}, r.deleteResource(ctx, app, canaryKey, &appv1.Deployment{})
+	}
+	if status.CanaryImage != app.Spec.Image {
+		*status = ingressv1beta1.AppRolloutStatus{
+			Phase:       ingressv1beta1.RolloutProgressing,
+			StableImage: status.StableImage,
+			CanaryImage: app.Spec.Image,
+			Step:        ptr.To[int32](0),
+		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, "CanaryStarted", "Started canary rollout of %s", app.Spec.Image)
+	}
+	steps := app.Spec.Rollout.Canary.Steps
+	step := steps[min(int(ptr.Deref(status.Step, 0)), len(steps)-1)]
+
+	// 金丝雀按照权重分得App的副本数，稳定版本减少相应的副本数，权重即为金丝雀的流量比例
+	total := ptr.Deref(app.Spec.Replicas, 1)
+	canaryReplicas := (total*step.Weight + 99) / 100
+	opts.Replicas = ptr.To(total - canaryReplicas)
+
+	canary, err := utils.NewDeploy(app, utils.DeployOptions{
+		ConfigChecksum: opts.ConfigChecksum,
+		Name:           app.CanaryName(),
+		Labels:         map[string]string{canaryTrackLabel: "canary"},
+		Replicas:       ptr.To(canaryReplicas),
+	})
+	if err != nil {
+		return ctrl.Result{}, r.renderFailed(ctx, app, "canary deployment", err)
+	}
+	if _, err := r.applyResource(ctx, app, canary, report); err != nil {
+		logger.Error(err, "apply canary deployment failed")
+		r.Recorder.Event(app, corev1.EventTypeWarning, "ApplyCanaryFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	live := &appv1.Deployment{}
+	if err := r.Get(ctx, canaryKey, live); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 金丝雀无法在progressDeadlineSeconds内就绪时中止发布，稳定版本恢复全部副本
+	if progressDeadlineExceeded(live) {
+		status.Phase = ingressv1beta1.RolloutAborted
+		status.Step, status.StepStartTime = nil, nil
+		status.Message = fmt.Sprintf("canary %s did not become ready", app.Spec.Image)
+		opts.Replicas = nil
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "CanaryAborted", "Aborted canary rollout of %s: %s", app.Spec.Image, status.Message)
+		return ctrl.Result{}, r.deleteResource(ctx, app, canaryKey, &appv1.Deployment{})
+	}
+	// 等待金丝雀就绪，Deployment的status变化会再次触发Reconcile
+	if !deploymentReady(live, canaryReplicas) {
+		status.Phase = ingressv1beta1.RolloutProgressing
+		status.StepStartTime = nil
+		status.Message = fmt.Sprintf("waiting for %d canary replicas of step %d to become ready", canaryReplicas, ptr.Deref(status.Step, 0))
+		return ctrl.Result{}, nil
+	}
+
+	now := metav1.Now()
+	if status.StepStartTime == nil {
+		status.StepStartTime = &now
+	}
+	if step.Pause != nil {
+		if remaining := step.Pause.Duration - now.Sub(status.StepStartTime.Time); remaining > 0 {
+			status.Phase = ingressv1beta1.RolloutPaused
+			status.Message = fmt.Sprintf("step %d paused at weight %d%%", ptr.Deref(status.Step, 0), step.Weight)
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	// 进入下一步
+	if next := ptr.Deref(status.Step, 0) + 1; int(next) < len(steps) {
+		status.Phase = ingressv1beta1.RolloutProgressing
+		status.Step, status.StepStartTime = ptr.To(next), nil
+		status.Message = fmt.Sprintf("moving to step %d at weight %d%%", next, steps[next].Weight)
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, "CanaryStepCompleted", "Completed canary step %d at weight %d%%", next-1, step.Weight)
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 所有步骤完成，将新镜像发布到稳定版本
+	*status = ingressv1beta1.AppRolloutStatus{
+		Phase:       ingressv1beta1.RolloutPromoted,
+		StableImage: app.Spec.Image,
+		Message:     fmt.Sprintf("promoted %s", app.Spec.Image),
+	}
+	opts.Image, opts.Replicas = app.Spec.Image, nil
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, "CanaryPromoted", "Promoted %s", app.Spec.Image)
+	return ctrl.Result{}, r.deleteResource(ctx, app, canaryKey, &appv1.Deployment{})
+}
+
+// replaceOnSelectorChange deletes the live Deployment when its selector, which
+// is immutable, differs from the one of deploy. The Deployment is deleted
+// orphaning its ReplicaSets, which the recreated Deployment adopts, so the
+// pods keep running. It reports whether the Deployment is being replaced.
+func (r *AppReconciler) replaceOnSelectorChange(ctx context.Context, app *ingressv1beta1.App, deploy *appv1.Deployment) (bool, error) {
+	live, err := r.getDeployment(ctx, client.ObjectKeyFromObject(deploy))
+	if err != nil || live == nil {
+		return false, err
+	}
+	// 等待orphan方式的删除完成后再创建
+	if !live.DeletionTimestamp.IsZero() {
+		return true, nil
+	}
+	if equality.Semantic.DeepEqual(live.Spec.Selector, deploy.Spec.Selector) {
+		return false, nil
+	}
+	// 不受App控制的Deployment按照adoptionPolicy决定是否接管
+	if !metav1.IsControlledBy(live, app) {
+		if err := r.adopt(ctx, app, live); err != nil {
+			return false, err
+		}
+	}
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, "ReplacingDeployment", "Recreating deployment %s to change its selector", live.Name)
+	err = r.Delete(ctx, live, client.PropagationPolicy(metav1.DeletePropagationOrphan), client.Preconditions{UID: &live.UID})
+	return true, client.IgnoreNotFound(err)
+}
+
+// deploymentReady reports whether the Deployment is available and runs the
+// given number of updated, ready replicas.
+func deploymentReady(deploy *appv1.Deployment, replicas int32) bool {
+	return deploy != nil && deploymentAvailable(deploy) &&
+		deploy.Status.UpdatedReplicas >= replicas && deploy.Status.ReadyReplicas >= replicas
+}
+
+// progressDeadlineExceeded reports whether the Deployment failed to make
+// progress within its progressDeadlineSeconds.
+func progressDeadlineExceeded(deploy *appv1.Deployment) bool {
+	for _, c := range deploy.Status.Conditions {
+		if c.Type == appv1.DeploymentProgressing {
+			return c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
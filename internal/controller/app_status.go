@@ -0,0 +1,294 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+)
+
+// Reasons used for the conditions in AppStatus.
+const (
+	ReasonAvailable          = "Available"
+	ReasonUnavailable        = "Unavailable"
+	ReasonNotFound           = "NotFound"
+	ReasonCreated            = "Created"
+	ReasonDisabled           = "Disabled"
+	ReasonReconcileFailed    = "ReconcileFailed"
+	ReasonRenderFailed       = "RenderFailed"
+	ReasonReconcileSucceeded = "ReconcileSucceeded"
+	ReasonResourcesReady     = "ResourcesReady"
+	ReasonResourcesNotReady  = "ResourcesNotReady"
+	ReasonSuspendedBySpec    = "SuspendedBySpec"
+	ReasonPausedByController = "PausedByController"
+	ReasonNotSuspended       = "NotSuspended"
+)
+
+// updateStatus observes the child resources of the App and writes the result,
+// together with the outcome of the last reconcile, through the status subresource.
+func (r *AppReconciler) updateStatus(ctx context.Context, app *ingressv1beta1.App, report *reconcileReport, reconcileErr error) error {
+	key := client.ObjectKeyFromObject(app)
+	status := app.Status.DeepCopy()
+	status.ObservedGeneration = app.Generation
+	status.Selector = labels.SelectorFromSet(labels.Set{"app": app.Name}).String()
+
+	setCondition := func(condType string, ok bool, reason, message string) {
+		condStatus := metav1.ConditionFalse
+		if ok {
+			condStatus = metav1.ConditionTrue
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               condType,
+			Status:             condStatus,
+			ObservedGeneration: app.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	// 子资源未全部处理完或暂停时，保留上次记录的漂移和发布状态
+	reconciled := reconcileErr == nil && !report.suspended
+	if reconciled {
+		status.DriftedFields = report.drifted
+		status.RolledBackRevision, status.RolledBackImage = report.rolledBackRevision, ""
+		if report.rolledBackRevision != 0 {
+			status.RolledBackImage = app.Spec.Image
+		}
+	}
+	if reconciled || report.rollout != nil {
+		status.Rollout = report.rollout
+	}
+
+	// Deployment，蓝绿发布时为生效颜色的Deployment
+	deployKey := key
+	if app.BlueGreenEnabled() && status.Rollout != nil && status.Rollout.ActiveColor != "" {
+		deployKey.Name = app.ColorDeploymentName(status.Rollout.ActiveColor)
+	}
+	deploy := &appv1.Deployment{}
+	deployReady := false
+	if err := r.Get(ctx, deployKey, deploy); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		status.Replicas, status.ReadyReplicas = 0, 0
+		setCondition(ingressv1beta1.ConditionDeploymentAvailable, false, ReasonNotFound, "deployment does not exist")
+	} else {
+		status.Replicas = deploy.Status.Replicas
+		if deploy.Spec.Replicas != nil {
+			status.Replicas = *deploy.Spec.Replicas
+		}
+		status.ReadyReplicas = deploy.Status.ReadyReplicas
+		deployReady = deploymentAvailable(deploy)
+		// 记录最近一次完整发布且可用的镜像，作为自动回滚的目标
+		if status.Replicas > 0 && deploymentReady(deploy, status.Replicas) && deploy.Status.Replicas == deploy.Status.UpdatedReplicas {
+			status.LastGoodImage = containerImage(deploy)
+			// Deployment按照App当前的版本渲染且运行当前的镜像时，当前版本即为最近一次成功发布的版本
+			if deploy.Annotations[revisionAnnotation] == strconv.FormatInt(app.Generation, 10) && status.LastGoodImage == app.Spec.Image {
+				status.LastGoodRevision = app.Generation
+			}
+		}
+		if deployReady {
+			setCondition(ingressv1beta1.ConditionDeploymentAvailable, true, ReasonAvailable,
+				fmt.Sprintf("%d/%d replicas ready", status.ReadyReplicas, status.Replicas))
+		} else {
+			setCondition(ingressv1beta1.ConditionDeploymentAvailable, false, ReasonUnavailable,
+				fmt.Sprintf("%d/%d replicas ready", status.ReadyReplicas, status.Replicas))
+		}
+	}
+
+	// Service
+	svcReady := true
+	if app.Spec.EnableSvc != nil && *app.Spec.EnableSvc {
+		svcReady = false
+		if err := r.Get(ctx, key, &corev1.Service{}); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			setCondition(ingressv1beta1.ConditionServiceReady, false, ReasonNotFound, "service does not exist")
+		} else {
+			svcReady = true
+			setCondition(ingressv1beta1.ConditionServiceReady, true, ReasonCreated, "service exists")
+		}
+	} else {
+		setCondition(ingressv1beta1.ConditionServiceReady, true, ReasonDisabled, "service is not enabled")
+	}
+
+	// Ingress
+	ingReady := true
+	status.URL = ""
+	if app.Spec.EnableIngress != nil && *app.Spec.EnableIngress && app.Spec.EnableSvc != nil && *app.Spec.EnableSvc {
+		ingReady = false
+		ing := &netv1.Ingress{}
+		if err := r.Get(ctx, key, ing); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			setCondition(ingressv1beta1.ConditionIngressReady, false, ReasonNotFound, "ingress does not exist")
+		} else {
+			ingReady = true
+			status.URL = ingressURL(ing)
+			setCondition(ingressv1beta1.ConditionIngressReady, true, ReasonCreated, "ingress exists")
+		}
+	} else {
+		setCondition(ingressv1beta1.ConditionIngressReady, true, ReasonDisabled, "ingress is not enabled")
+	}
+
+	// 只有在所有子资源都处理完成或接管失败时才能确定Adopted的状态
+	var adoptErr *adoptionError
+	if stderrors.As(reconcileErr, &adoptErr) {
+		setCondition(ingressv1beta1.ConditionAdopted, false, adoptErr.reason, adoptErr.message)
+	} else if reconciled {
+		setCondition(ingressv1beta1.ConditionAdopted, true, ReasonChildrenAdopted, "all child resources are controlled by the app")
+	}
+
+	// 只有开启自动回滚或已经回滚时才报告RolledBack
+	switch {
+	case !reconciled:
+	case status.RolledBackRevision != 0:
+		setCondition(ingressv1beta1.ConditionRolledBack, true, ReasonProgressDeadlineExceeded,
+			fmt.Sprintf("rollout of revision %d failed, rolled back to revision %d", status.RolledBackRevision, status.LastGoodRevision))
+	case app.AutoRollbackEnabled():
+		setCondition(ingressv1beta1.ConditionRolledBack, false, ReasonRolloutHealthy, "")
+	default:
+		meta.RemoveStatusCondition(&status.Conditions, ingressv1beta1.ConditionRolledBack)
+	}
+
+	switch {
+	case app.Spec.Suspend:
+		setCondition(ingressv1beta1.ConditionSuspended, true, ReasonSuspendedBySpec, "spec.suspend is set")
+	case report.suspended:
+		setCondition(ingressv1beta1.ConditionSuspended, true, ReasonPausedByController, "reconciliation is paused for all apps")
+	default:
+		setCondition(ingressv1beta1.ConditionSuspended, false, ReasonNotSuspended, "")
+	}
+
+	if reconcileErr != nil {
+		setCondition(ingressv1beta1.ConditionReconcileError, true, errorReason(reconcileErr), reconcileErr.Error())
+	} else {
+		setCondition(ingressv1beta1.ConditionReconcileError, false, ReasonReconcileSucceeded, "")
+	}
+
+	if reconcileErr == nil && deployReady && svcReady && ingReady {
+		setCondition(ingressv1beta1.ConditionReady, true, ReasonResourcesReady, "all resources are ready")
+	} else {
+		setCondition(ingressv1beta1.ConditionReady, false, ReasonResourcesNotReady, "waiting for resources to become ready")
+	}
+
+	if equality.Semantic.DeepEqual(&app.Status, status) {
+		return nil
+	}
+	patch := client.MergeFrom(app.DeepCopy())
+	app.Status = *status
+	return r.Status().Patch(ctx, app, patch)
+}
+
+// reasonError carries the condition reason to report for a failed reconcile.
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e *reasonError) Error() string { return e.err.Error() }
+
+func (e *reasonError) Unwrap() error { return e.err }
+
+// withReason annotates err with the reason reported in the ReconcileError condition.
+func withReason(reason string, err error) error {
+	return &reasonError{reason: reason, err: err}
+}
+
+// errorReason returns the reason err was annotated with, or ReasonReconcileFailed.
+func errorReason(err error) string {
+	var re *reasonError
+	if stderrors.As(err, &re) {
+		return re.reason
+	}
+	return ReasonReconcileFailed
+}
+
+// deploymentAvailable reports whether the Deployment has observed its latest
+// spec and reports the Available condition.
+func deploymentAvailable(deploy *appv1.Deployment) bool {
+	if deploy.Status.ObservedGeneration < deploy.Generation {
+		return false
+	}
+	for _, c := range deploy.Status.Conditions {
+		if c.Type == appv1.DeploymentAvailable {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ingressURL returns the URL of the first rule of the Ingress.
+func ingressURL(ing *netv1.Ingress) string {
+	if len(ing.Spec.Rules) == 0 || ing.Spec.Rules[0].Host == "" {
+		return ""
+	}
+	host := ing.Spec.Rules[0].Host
+	scheme := "http"
+	for _, tls := range ing.Spec.TLS {
+		for _, h := range tls.Hosts {
+			if h == host {
+				scheme = "https"
+			}
+		}
+	}
+	return scheme + "://" + host
+}
+
+// childChangedPredicate 在子资源的spec、labels或annotations变化时触发，用于检测漂移
+func childChangedPredicate() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+}
+
+// deploymentStatusChangedPredicate 仅在Deployment的status发生变化时触发，用于刷新App的status
+func deploymentStatusChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldDeploy, ok := e.ObjectOld.(*appv1.Deployment)
+			if !ok {
+				return false
+			}
+			newDeploy, ok := e.ObjectNew.(*appv1.Deployment)
+			if !ok {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldDeploy.Status, newDeploy.Status)
+		},
+	}
+}
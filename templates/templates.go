@@ -0,0 +1,10 @@
+// Package templates contains the manifests the App controller renders its
+// child resources from. They are compiled into the manager binary.
+package templates
+
+import "embed"
+
+// FS holds the built-in *.yml templates.
+//
+//go:embed *.yml
+var FS embed.FS
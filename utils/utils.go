@@ -2,48 +2,244 @@ package utils
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/template"
+
 	ingressv1beta1 "github.com/hdssbks/kubebuilder-demo/api/v1beta1"
+	"github.com/hdssbks/kubebuilder-demo/templates"
 	appv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/yaml"
-	"text/template"
 )
 
-func parseTemplate(resource string, app *ingressv1beta1.App) []byte {
+// templateDir 不为空时，优先从该目录读取模板，不存在的模板仍使用编译进二进制的版本
+var templateDir string
+
+// SetTemplateDir makes the templates be read from dir, falling back to the
+// embedded templates for the files dir does not contain. An empty dir only
+// uses the embedded templates.
+func SetTemplateDir(dir string) {
+	templateDir = dir
+}
+
+var funcs = template.FuncMap{
+	// toJson 将值渲染为JSON，JSON同时也是合法的YAML，可以避免特殊字符破坏模板
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func readTemplate(name string) ([]byte, error) {
+	if templateDir != "" {
+		b, err := os.ReadFile(filepath.Join(templateDir, name))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return b, err
+		}
+	}
+	return fs.ReadFile(templates.FS, name)
+}
+
+// DeployOptions holds the values used to render a Deployment that cannot be
+// derived from the App itself.
+type DeployOptions struct {
+	// ConfigChecksum is set as a pod template annotation, so that pods are
+	// rolled when the configuration they reference changes.
+	ConfigChecksum string
+	// Name overrides the name of the Deployment, which defaults to the App's name.
+	Name string
+	// Image overrides the image of the container, which defaults to the App's image.
+	Image string
+	// Labels are added to the selector and the pod labels of the Deployment.
+	Labels map[string]string
+	// ExcludeLabels are added to the selector as NotIn requirements, so that
+	// the Deployment does not select the pods of other Deployments of the App.
+	ExcludeLabels map[string]string
+	// Replicas overrides the replicas of the Deployment. Without it the
+	// replicas of the App are used, unless the App is autoscaled.
+	Replicas *int32
+	// Revision is the revision of the App's spec the Deployment is rendered
+	// from, recorded in an annotation of the Deployment.
+	Revision int64
+}
+
+// deployData 是渲染deployment模板时使用的数据，模板中仍可以直接访问App的字段和方法
+type deployData struct {
+	*ingressv1beta1.App
+	DeployOptions
+}
+
+// DeployName returns the name of the Deployment.
+func (d deployData) DeployName() string {
+	if d.DeployOptions.Name != "" {
+		return d.DeployOptions.Name
+	}
+	return d.App.Name
+}
+
+// DeployImage returns the image of the container.
+func (d deployData) DeployImage() string {
+	if d.DeployOptions.Image != "" {
+		return d.DeployOptions.Image
+	}
+	return d.App.Spec.Image
+}
+
+// PodLabels returns the labels selecting the pods of the Deployment.
+func (d deployData) PodLabels() map[string]string {
+	labels := map[string]string{"app": d.App.Name}
+	for k, v := range d.DeployOptions.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// DeployReplicas returns the replicas of the Deployment, nil when they are
+// left to the HorizontalPodAutoscaler.
+func (d deployData) DeployReplicas() *int32 {
+	if d.DeployOptions.Replicas != nil {
+		return d.DeployOptions.Replicas
+	}
+	if d.App.Spec.Autoscaling != nil {
+		return nil
+	}
+	return d.App.Spec.Replicas
+}
+
+// ServiceOptions holds the values used to render a Service that cannot be
+// derived from the App itself.
+type ServiceOptions struct {
+	// Name overrides the name of the Service, which defaults to the App's name.
+	Name string
+	// Selector overrides the pod selector of the Service, which defaults to all
+	// pods of the App.
+	Selector map[string]string
+}
+
+// serviceData 是渲染service模板时使用的数据
+type serviceData struct {
+	*ingressv1beta1.App
+	ServiceOptions
+}
+
+// ServiceName returns the name of the Service.
+func (d serviceData) ServiceName() string {
+	if d.ServiceOptions.Name != "" {
+		return d.ServiceOptions.Name
+	}
+	return d.App.Name
+}
+
+// ServiceSelector returns the pod selector of the Service.
+func (d serviceData) ServiceSelector() map[string]string {
+	if d.ServiceOptions.Selector != nil {
+		return d.ServiceOptions.Selector
+	}
+	return map[string]string{"app": d.App.Name}
+}
+
+// PDBOptions customises the PodDisruptionBudget rendered for an App.
+type PDBOptions struct {
+	// MinAvailable overrides the minAvailable of the App.
+	MinAvailable *intstr.IntOrString
+}
+
+// pdbData 是渲染pdb模板时使用的数据
+type pdbData struct {
+	*ingressv1beta1.App
+	PDBOptions
+}
+
+// PDBMinAvailable returns the minAvailable of the PodDisruptionBudget.
+func (d pdbData) PDBMinAvailable() *intstr.IntOrString {
+	if d.PDBOptions.MinAvailable != nil {
+		return d.PDBOptions.MinAvailable
+	}
+	return d.App.Spec.MinAvailable
+}
+
+func parseTemplate(resource string, data interface{}) ([]byte, error) {
 	// 解析模板
-	tpl, err := template.ParseFiles("templates/" + resource + ".yml")
+	name := resource + ".yml"
+	content, err := readTemplate(name)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("read template %s: %w", name, err)
+	}
+	tpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", name, err)
 	}
 	b := new(bytes.Buffer)
-	err = tpl.Execute(b, app)
+	if err := tpl.Execute(b, data); err != nil {
+		return nil, fmt.Errorf("render template %s: %w", name, err)
+	}
+	return b.Bytes(), nil
+}
+
+func render(resource string, data interface{}, obj interface{}) error {
+	b, err := parseTemplate(resource, data)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := yaml.Unmarshal(b, obj); err != nil {
+		return fmt.Errorf("decode rendered %s: %w", resource, err)
 	}
-	return b.Bytes()
+	return nil
 }
 
-func NewDeploy(app *ingressv1beta1.App) *appv1.Deployment {
+func NewDeploy(app *ingressv1beta1.App, opts DeployOptions) (*appv1.Deployment, error) {
 	deploy := &appv1.Deployment{}
-	if err := yaml.Unmarshal(parseTemplate("deployment", app), deploy); err != nil {
-		panic(err)
+	if err := render("deployment", deployData{App: app, DeployOptions: opts}, deploy); err != nil {
+		return nil, err
 	}
-	return deploy
+	return deploy, nil
 }
 
-func NewService(app *ingressv1beta1.App) *corev1.Service {
+func NewService(app *ingressv1beta1.App, opts ServiceOptions) (*corev1.Service, error) {
 	service := &corev1.Service{}
-	if err := yaml.Unmarshal(parseTemplate("service", app), &service); err != nil {
-		panic(err)
+	if err := render("service", serviceData{App: app, ServiceOptions: opts}, service); err != nil {
+		return nil, err
 	}
-	return service
+	return service, nil
 }
 
-func NewIngress(app *ingressv1beta1.App) *netv1.Ingress {
+func NewIngress(app *ingressv1beta1.App) (*netv1.Ingress, error) {
 	ingress := &netv1.Ingress{}
-	if err := yaml.Unmarshal(parseTemplate("ingress", app), &ingress); err != nil {
-		panic(err)
+	if err := render("ingress", app, ingress); err != nil {
+		return nil, err
+	}
+	return ingress, nil
+}
+
+func NewConfigMap(app *ingressv1beta1.App) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := render("configmap", app, configMap); err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func NewHPA(app *ingressv1beta1.App) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := render("hpa", app, hpa); err != nil {
+		return nil, err
+	}
+	return hpa, nil
+}
+
+func NewPDB(app *ingressv1beta1.App, opts PDBOptions) (*policyv1.PodDisruptionBudget, error) {
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := render("pdb", pdbData{App: app, PDBOptions: opts}, pdb); err != nil {
+		return nil, err
 	}
-	return ingress
+	return pdb, nil
 }